@@ -1,6 +1,7 @@
 package chapter04
 
 import (
+	"errors"
 	"io"
 	"net"
 )
@@ -18,12 +19,38 @@ func proxyConn(src, dst string) error {
 	}
 	defer connDst.Close()
 
-	go func() {
-		// handle replies
-		/*		You don’t need to worry about leaking this goroutine, since io.Copy will
-				return when either connection is closed.
-		*/io.Copy(connSource, connDst)
-	}()
-	_, err = io.Copy(connDst, connSource)
+	return Proxy(connSource, connDst)
+}
+
+// Proxy copies data between a and b in both directions until both are done.
+// When one side finishes sending, Proxy half-closes the other side's write
+// direction if it supports it, as TCP connections do, so the end of the
+// stream propagates. If a copy fails, or the other side can't half-close,
+// Proxy closes both connections so the copy in the other direction doesn't
+// wait on a peer that may never close. The caller still owns, and closes,
+// both connections.
+func Proxy(a, b net.Conn) error {
+	errs := make(chan error, 2)
+	pipe := func(dst, src net.Conn) {
+		_, err := io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok && err == nil {
+			_ = cw.CloseWrite()
+		} else {
+			// unblock the copy in the other direction
+			_ = dst.Close()
+			_ = src.Close()
+		}
+		errs <- err
+	}
+	go pipe(a, b)
+	go pipe(b, a)
+
+	var err error
+	for i := 0; i < 2; i++ {
+		// closing one direction fails reads in the other
+		if e := <-errs; e != nil && err == nil && !errors.Is(e, net.ErrClosed) {
+			err = e
+		}
+	}
 	return err
 }
@@ -7,6 +7,7 @@ import (
 	"net"
 	"sync"
 	"testing"
+	"time"
 )
 
 // more generic proxy
@@ -146,3 +147,129 @@ func TestProxy(t *testing.T) {
 	_ = server.Close()
 	wg.Wait()
 }
+
+func TestProxyHalfClose(t *testing.T) {
+	// the server reads until the client is done sending, then replies
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		_, _ = conn.Write(append([]byte("got "), b...))
+	}()
+
+	proxyServer, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyServer.Close()
+	done := make(chan error, 1)
+	go func() {
+		from, err := proxyServer.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer from.Close()
+		to, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			done <- err
+			return
+		}
+		defer to.Close()
+		done <- Proxy(from, to)
+	}()
+
+	client, err := net.Dial("tcp", proxyServer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err = client.Write([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	// the end of the request must reach the server through the proxy
+	if err = client.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "got request" {
+		t.Fatalf("expected %q; actual %q", "got request", b)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProxyCopyError(t *testing.T) {
+	// the server never replies nor closes its end
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	release := make(chan struct{})
+	defer close(release)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-release
+	}()
+
+	proxyServer, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyServer.Close()
+	accepted := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		from, err := proxyServer.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer from.Close()
+		to, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			done <- err
+			return
+		}
+		defer to.Close()
+		close(accepted)
+		done <- Proxy(from, to)
+	}()
+
+	client, err := net.Dial("tcp", proxyServer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-accepted
+	// reset the connection instead of closing it cleanly
+	if err = client.(*net.TCPConn).SetLinger(0); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.Close()
+
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Error("expected the reset to be reported")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy kept waiting on the server after the client reset")
+	}
+}
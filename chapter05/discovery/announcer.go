@@ -0,0 +1,90 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+const defaultInterval = time.Second
+
+// Announcer periodically advertises a Service on a multicast group, and
+// optionally on a broadcast address, until its context is canceled. It also
+// answers browser queries right away so new browsers don't have to wait for
+// the next interval.
+type Announcer struct {
+	Service   Service
+	Group     *net.UDPAddr   // multicast group; DefaultGroup if nil
+	Broadcast *net.UDPAddr   // optional broadcast destination, e.g. 255.255.255.255:9999
+	Interface *net.Interface // interface to join the group on; nil lets the system pick
+	Interval  time.Duration  // time between announcements; defaults to 1 second
+	TTL       time.Duration  // lifetime of each announcement; defaults to 3 intervals
+}
+
+// Announce blocks, announcing the service until ctx is canceled. It then
+// sends a goodbye so browsers remove the service without waiting for its TTL
+// to expire.
+func (a *Announcer) Announce(ctx context.Context) error {
+	if a.Service.Name == "" {
+		return errors.New("service name is required")
+	}
+	interval := a.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	ttl := a.TTL
+	if ttl <= 0 {
+		ttl = 3 * interval
+	}
+
+	conn, err := listenGroup(ctx, a.Group, a.Interface)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	announce := Message{Op: OpAnnounce, TTL: ttl, Service: a.Service}
+	if _, err = announce.MarshalBinary(); err != nil {
+		return err
+	}
+
+	queries := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, MaxMessageSize)
+		var m Message
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if m.UnmarshalBinary(buf[:n]) != nil || m.Op != OpQuery {
+				continue
+			}
+			if m.Service.Name != "" && m.Service.Name != a.Service.Name {
+				continue
+			}
+			select {
+			case queries <- struct{}{}:
+			default: // an announcement is already pending
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// a failed write is retried on the next tick; the TTL covers a few
+		// lost announcements
+		_ = conn.send(announce, conn.group, a.Broadcast)
+
+		select {
+		case <-ctx.Done():
+			goodbye := Message{Op: OpGoodbye, Service: a.Service}
+			return conn.send(goodbye, conn.group, a.Broadcast)
+		case <-queries:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+type EventType uint8
+
+const (
+	EventAdded   EventType = iota + 1 // a service instance was announced for the first time
+	EventUpdated                      // a known instance changed its metadata
+	EventRemoved                      // an instance said goodbye or its TTL expired
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventAdded:
+		return "added"
+	case EventUpdated:
+		return "updated"
+	case EventRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+type Event struct {
+	Type    EventType
+	Service Service
+	Source  net.Addr // the sender of the announcement; nil for expirations
+}
+
+type entry struct {
+	service Service
+	expires time.Time
+}
+
+// instance identifies one instance of a service; replicas share a name but
+// not an address.
+type instance struct {
+	name, addr string
+}
+
+// Browser listens on a multicast group and keeps track of the service
+// instances announced there. Instances are keyed by name and address, so
+// replicas of a service are tracked separately, and an instance that moves to
+// a new address is reported as added there and, once it says goodbye or
+// expires, removed from the old one.
+type Browser struct {
+	Name      string         // only report services with this name; empty means all
+	Group     *net.UDPAddr   // multicast group; DefaultGroup if nil
+	Interface *net.Interface // interface to join the group on; nil lets the system pick
+
+	mu       sync.Mutex
+	services map[instance]entry
+}
+
+// Browse joins the group, asks services to announce themselves and returns a
+// channel of events. The channel is closed once ctx is canceled.
+func (b *Browser) Browse(ctx context.Context) (<-chan Event, error) {
+	conn, err := listenGroup(ctx, b.Group, b.Interface)
+	if err != nil {
+		return nil, err
+	}
+
+	query := Message{Op: OpQuery, Service: Service{Name: b.Name}}
+	if err = conn.send(query, conn.group); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	b.mu.Lock()
+	b.services = make(map[instance]entry)
+	b.mu.Unlock()
+
+	type datagram struct {
+		msg  Message
+		from net.Addr
+	}
+	incoming := make(chan datagram)
+	go func() {
+		defer close(incoming)
+		buf := make([]byte, MaxMessageSize)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var m Message
+			if m.UnmarshalBinary(buf[:n]) != nil || m.Op == OpQuery {
+				continue
+			}
+			if b.Name != "" && m.Service.Name != b.Name {
+				continue
+			}
+			select {
+			case incoming <- datagram{msg: m, from: from}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer conn.Close()
+
+		// the timer fires when the next service expires
+		expiry := time.NewTimer(time.Hour)
+		defer expiry.Stop()
+
+		emit := func(e Event) bool {
+			select {
+			case events <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			var pending []Event
+			select {
+			case <-ctx.Done():
+				return
+			case d, ok := <-incoming:
+				if !ok {
+					return
+				}
+				pending = b.apply(d.msg, d.from, time.Now())
+			case now := <-expiry.C:
+				pending = b.expire(now)
+			}
+
+			for _, e := range pending {
+				if !emit(e) {
+					return
+				}
+			}
+
+			if !expiry.Stop() {
+				select {
+				case <-expiry.C:
+				default:
+				}
+			}
+			expiry.Reset(b.nextExpiry(time.Now()))
+		}
+	}()
+
+	return events, nil
+}
+
+// Services returns the currently known service instances sorted by name and
+// address.
+func (b *Browser) Services() []Service {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := make([]Service, 0, len(b.services))
+	for _, e := range b.services {
+		s = append(s, e.service)
+	}
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].Name != s[j].Name {
+			return s[i].Name < s[j].Name
+		}
+		return s[i].Addr < s[j].Addr
+	})
+	return s
+}
+
+func (b *Browser) apply(m Message, from net.Addr, now time.Time) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := instance{name: m.Service.Name, addr: m.Service.Addr}
+	current, known := b.services[key]
+	switch m.Op {
+	case OpGoodbye:
+		if !known {
+			return nil
+		}
+		delete(b.services, key)
+		return []Event{{Type: EventRemoved, Service: current.service, Source: from}}
+	case OpAnnounce:
+		b.services[key] = entry{service: m.Service, expires: now.Add(m.TTL)}
+		switch {
+		case !known:
+			return []Event{{Type: EventAdded, Service: m.Service, Source: from}}
+		case !current.service.equal(m.Service):
+			return []Event{{Type: EventUpdated, Service: m.Service, Source: from}}
+		}
+	}
+	return nil
+}
+
+func (b *Browser) expire(now time.Time) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var events []Event
+	for key, e := range b.services {
+		if !now.Before(e.expires) {
+			delete(b.services, key)
+			events = append(events, Event{Type: EventRemoved, Service: e.service})
+		}
+	}
+	return events
+}
+
+func (b *Browser) nextExpiry(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	next := time.Hour
+	for _, e := range b.services {
+		if d := e.expires.Sub(now); d < next {
+			next = d
+		}
+	}
+	if next < 0 {
+		next = 0
+	}
+	return next
+}
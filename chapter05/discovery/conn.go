@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	"go.uber.org/multierr"
+	"golang.org/x/net/ipv4"
+)
+
+// groupConn is a UDP socket bound to the group's port that has joined the
+// multicast group. Announcers and browsers on the same host share the port,
+// so the socket is bound with SO_REUSEADDR and SO_REUSEPORT.
+type groupConn struct {
+	net.PacketConn
+	p     *ipv4.PacketConn
+	group *net.UDPAddr
+	ifi   *net.Interface
+}
+
+func listenGroup(ctx context.Context, group *net.UDPAddr, ifi *net.Interface) (*groupConn, error) {
+	if group == nil {
+		group = DefaultGroup
+	}
+	if group.IP.To4() == nil || !group.IP.IsMulticast() {
+		return nil, fmt.Errorf("%s: not an IPv4 multicast group", group)
+	}
+
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var sErr error
+			err := c.Control(func(fd uintptr) {
+				sErr = setSockopts(fd)
+			})
+			if err != nil {
+				return err
+			}
+			return sErr
+		},
+	}
+	addr := fmt.Sprintf("0.0.0.0:%d", group.Port)
+	c, err := lc.ListenPacket(ctx, "udp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("binding to udp: %s: %w", addr, err)
+	}
+
+	p := ipv4.NewPacketConn(c)
+	err = p.JoinGroup(ifi, &net.UDPAddr{IP: group.IP})
+	if err == nil && ifi != nil {
+		err = p.SetMulticastInterface(ifi)
+	}
+	if err == nil {
+		// deliver our own announcements to browsers on this host
+		err = p.SetMulticastLoopback(true)
+	}
+	if err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("joining group %s: %w", group, err)
+	}
+
+	return &groupConn{PacketConn: c, p: p, group: group, ifi: ifi}, nil
+}
+
+func (g *groupConn) send(m Message, to ...*net.UDPAddr) error {
+	b, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if addr == nil {
+			continue
+		}
+		if _, wErr := g.WriteTo(b, addr); wErr != nil {
+			err = multierr.Append(err, fmt.Errorf("write to %s: %w", addr, wErr))
+		}
+	}
+	return err
+}
+
+func (g *groupConn) Close() error {
+	_ = g.p.LeaveGroup(g.ifi, &net.UDPAddr{IP: g.group.IP})
+	return g.PacketConn.Close()
+}
@@ -0,0 +1,281 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMessageMarshal(t *testing.T) {
+	testCases := []Message{
+		{Op: OpAnnounce, TTL: 3 * time.Second, Service: Service{
+			Name: "housework", Addr: "127.0.0.1:34443",
+			Meta: map[string]string{"version": "v1", "proto": "grpc"}}},
+		{Op: OpGoodbye, Service: Service{Name: "housework", Addr: "127.0.0.1:34443"}},
+		{Op: OpQuery},
+	}
+
+	for i, c := range testCases {
+		b, err := c.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		var actual Message
+		if err = actual.UnmarshalBinary(b); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !reflect.DeepEqual(c, actual) {
+			t.Errorf("%d: expected %#v; actual %#v", i, c, actual)
+		}
+		// a truncated datagram must never decode
+		if err = actual.UnmarshalBinary(b[:len(b)-1]); err == nil {
+			t.Errorf("%d: expected error for truncated message", i)
+		}
+	}
+
+	if err := new(Message).UnmarshalBinary([]byte("ping")); err != ErrInvalidMessage {
+		t.Errorf("expected %v; actual %v", ErrInvalidMessage, err)
+	}
+}
+
+func loopback(t *testing.T) *net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagLoopback != 0 && ifaces[i].Flags&net.FlagUp != 0 {
+			return &ifaces[i]
+		}
+	}
+	t.Skip("no loopback interface")
+	return nil
+}
+
+// testGroup returns the default group on a port nobody else is using.
+func testGroup(t *testing.T) *net.UDPAddr {
+	c, err := net.ListenPacket("udp4", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return &net.UDPAddr{IP: DefaultGroup.IP, Port: c.LocalAddr().(*net.UDPAddr).Port}
+}
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("events channel closed")
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return Event{}
+}
+
+func TestAnnounceBrowse(t *testing.T) {
+	lo := loopback(t)
+	group := testGroup(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := &Browser{Name: "housework", Group: group, Interface: lo}
+	events, err := b.Browse(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := Service{Name: "housework", Addr: "127.0.0.1:34443",
+		Meta: map[string]string{"version": "v1"}}
+	aCtx, aCancel := context.WithCancel(ctx)
+	done := make(chan error, 2)
+	a := &Announcer{Service: svc, Group: group, Interface: lo,
+		Interval: 50 * time.Millisecond}
+	go func() { done <- a.Announce(aCtx) }()
+
+	// services with other names are filtered out by the browser
+	other := &Announcer{Service: Service{Name: "other"}, Group: group, Interface: lo}
+	go func() { _ = other.Announce(ctx) }()
+
+	e := nextEvent(t, events)
+	if e.Type != EventAdded || !e.Service.equal(svc) {
+		t.Fatalf("expected added %v; actual %v %v", svc, e.Type, e.Service)
+	}
+	if s := b.Services(); len(s) != 1 || s[0].Name != svc.Name {
+		t.Fatalf("unexpected services: %v", s)
+	}
+
+	aCancel()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	e = nextEvent(t, events)
+	if e.Type != EventRemoved || e.Service.Name != svc.Name {
+		t.Fatalf("expected removed %q; actual %v %q", svc.Name, e.Type, e.Service.Name)
+	}
+
+	// a new version of the instance replaces the old one's metadata
+	aCtx, aCancel = context.WithCancel(ctx)
+	defer aCancel()
+	go func() { done <- a.Announce(aCtx) }()
+	if e = nextEvent(t, events); e.Type != EventAdded {
+		t.Fatalf("expected added; actual %v", e.Type)
+	}
+	updated := &Announcer{Service: Service{Name: svc.Name, Addr: svc.Addr,
+		Meta: map[string]string{"version": "v2"}},
+		Group: group, Interface: lo, Interval: time.Hour}
+	uCtx, uCancel := context.WithCancel(ctx)
+	defer uCancel()
+	go func() { _ = updated.Announce(uCtx) }()
+	if e = nextEvent(t, events); e.Type != EventUpdated || e.Service.Meta["version"] != "v2" {
+		t.Fatalf("expected updated to %q; actual %v %v", "v2", e.Type, e.Service.Meta)
+	}
+
+	cancel()
+	for range events {
+		// drain until the browser closes the channel
+	}
+}
+
+func TestAnnounceShared(t *testing.T) {
+	lo := loopback(t)
+	group := testGroup(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := &Browser{Name: "housework", Group: group, Interface: lo}
+	events, err := b.Browse(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Interval and TTL keep their defaults
+	a := &Announcer{Service: Service{Name: "housework", Addr: "127.0.0.1:34443"},
+		Group: group, Interface: lo}
+	aCtx, aCancel := context.WithCancel(ctx)
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- a.Announce(aCtx) }()
+	}
+	if e := nextEvent(t, events); e.Type != EventAdded {
+		t.Fatalf("expected added; actual %v", e.Type)
+	}
+	aCancel()
+	for i := 0; i < 2; i++ {
+		if err = <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.Interval != 0 || a.TTL != 0 {
+		t.Errorf("Announce changed the configuration: %+v", a)
+	}
+
+	cancel()
+	for range events {
+		// drain until the browser closes the channel
+	}
+}
+
+func TestBrowseInstances(t *testing.T) {
+	lo := loopback(t)
+	group := testGroup(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := &Browser{Name: "housework", Group: group, Interface: lo}
+	events, err := b.Browse(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two replicas of one service
+	addrs := []string{"127.0.0.1:34443", "127.0.0.1:44443"}
+	cancels := make([]context.CancelFunc, len(addrs))
+	done := make(chan error, len(addrs))
+	for i, addr := range addrs {
+		a := &Announcer{Service: Service{Name: "housework", Addr: addr},
+			Group: group, Interface: lo, Interval: 20 * time.Millisecond}
+		var aCtx context.Context
+		aCtx, cancels[i] = context.WithCancel(ctx)
+		defer cancels[i]()
+		go func() { done <- a.Announce(aCtx) }()
+	}
+
+	added := map[string]bool{}
+	for len(added) < len(addrs) {
+		e := nextEvent(t, events)
+		if e.Type != EventAdded {
+			t.Fatalf("expected added; actual %v %q", e.Type, e.Service.Addr)
+		}
+		added[e.Service.Addr] = true
+	}
+
+	// repeated announcements of either replica change nothing
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event %v %q", e.Type, e.Service.Addr)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if s := b.Services(); len(s) != 2 || s[0].Addr != addrs[0] || s[1].Addr != addrs[1] {
+		t.Fatalf("unexpected services: %v", s)
+	}
+
+	// a goodbye from one replica leaves the other
+	cancels[0]()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	e := nextEvent(t, events)
+	if e.Type != EventRemoved || e.Service.Addr != addrs[0] {
+		t.Fatalf("expected removed %q; actual %v %q", addrs[0], e.Type, e.Service.Addr)
+	}
+	if s := b.Services(); len(s) != 1 || s[0].Addr != addrs[1] {
+		t.Fatalf("unexpected services: %v", s)
+	}
+}
+
+func TestBrowseExpiry(t *testing.T) {
+	lo := loopback(t)
+	group := testGroup(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := &Browser{Group: group, Interface: lo}
+	events, err := b.Browse(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// announce once by hand so no goodbye is ever sent
+	conn, err := listenGroup(ctx, group, lo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	svc := Service{Name: "ephemeral", Addr: "127.0.0.1:1"}
+	err = conn.send(Message{Op: OpAnnounce, TTL: 100 * time.Millisecond, Service: svc}, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if e := nextEvent(t, events); e.Type != EventAdded {
+		t.Fatalf("expected added; actual %v", e.Type)
+	}
+	e := nextEvent(t, events)
+	if e.Type != EventRemoved || e.Source != nil {
+		t.Fatalf("expected expiry; actual %v from %v", e.Type, e.Source)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("service expired after %s", elapsed)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package discovery
+
+import "golang.org/x/sys/unix"
+
+// setSockopts lets several announcers and browsers on one host bind the
+// group's port at the same time, and allows announcements to be sent to a
+// broadcast address.
+func setSockopts(fd uintptr) error {
+	for _, opt := range []int{unix.SO_REUSEADDR, unix.SO_REUSEPORT, unix.SO_BROADCAST} {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, opt, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
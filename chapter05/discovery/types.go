@@ -0,0 +1,185 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+// Every discovery datagram uses the following layout. All integers are big
+// endian and every string is prefixed with its length:
+//
+//	magic    4 bytes  "GNSD"
+//	version  1 byte   currently 1
+//	op       1 byte   OpAnnounce, OpGoodbye or OpQuery
+//	ttl      4 bytes  announcement lifetime in milliseconds
+//	name     1 byte length + service name
+//	addr     1 byte length + service address (host:port)
+//	count    1 byte number of metadata entries
+//	entries  count * (1 byte key length + key, 2 byte value length + value)
+//
+// A query carries the name it browses for (empty means every service) and
+// leaves the address and metadata empty.
+
+const (
+	Version        = 1
+	MaxMessageSize = 1472 // the largest UDP payload that fits an Ethernet frame
+)
+
+var (
+	magic = [4]byte{'G', 'N', 'S', 'D'}
+
+	// DefaultGroup is the administratively scoped multicast group used when
+	// an Announcer or Browser doesn't specify one.
+	DefaultGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 42, 99), Port: 9999}
+
+	ErrInvalidMessage  = errors.New("invalid discovery message")
+	ErrMessageTooLarge = errors.New("discovery message too large")
+)
+
+type OpCode uint8
+
+const (
+	OpAnnounce OpCode = iota + 1 // a service is (still) available
+	OpGoodbye                    // a service is going away
+	OpQuery                      // a browser asks services to announce themselves
+)
+
+// Service describes one announced service instance.
+type Service struct {
+	Name string
+	Addr string
+	Meta map[string]string
+}
+
+func (s Service) equal(o Service) bool {
+	if s.Name != o.Name || s.Addr != o.Addr || len(s.Meta) != len(o.Meta) {
+		return false
+	}
+	for k, v := range s.Meta {
+		if ov, ok := o.Meta[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+type Message struct {
+	Op      OpCode
+	TTL     time.Duration
+	Service Service
+}
+
+func (m Message) MarshalBinary() ([]byte, error) {
+	if m.Op < OpAnnounce || m.Op > OpQuery {
+		return nil, ErrInvalidMessage
+	}
+	if len(m.Service.Name) > 0xff || len(m.Service.Addr) > 0xff || len(m.Service.Meta) > 0xff {
+		return nil, ErrMessageTooLarge
+	}
+
+	b := new(bytes.Buffer)
+	b.Grow(256)
+	b.Write(magic[:])
+	b.WriteByte(Version)
+	b.WriteByte(byte(m.Op))
+	_ = binary.Write(b, binary.BigEndian, uint32(m.TTL/time.Millisecond))
+	b.WriteByte(byte(len(m.Service.Name)))
+	b.WriteString(m.Service.Name)
+	b.WriteByte(byte(len(m.Service.Addr)))
+	b.WriteString(m.Service.Addr)
+
+	// sort the keys so the same service always encodes to the same bytes
+	keys := make([]string, 0, len(m.Service.Meta))
+	for k := range m.Service.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b.WriteByte(byte(len(keys)))
+	for _, k := range keys {
+		v := m.Service.Meta[k]
+		if len(k) > 0xff || len(v) > 0xffff {
+			return nil, ErrMessageTooLarge
+		}
+		b.WriteByte(byte(len(k)))
+		b.WriteString(k)
+		_ = binary.Write(b, binary.BigEndian, uint16(len(v)))
+		b.WriteString(v)
+	}
+
+	if b.Len() > MaxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+	return b.Bytes(), nil
+}
+
+func (m *Message) UnmarshalBinary(p []byte) error {
+	r := bytes.NewReader(p)
+
+	var header struct {
+		Magic   [4]byte
+		Version uint8
+		Op      OpCode
+		TTL     uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return ErrInvalidMessage
+	}
+	if header.Magic != magic || header.Version != Version ||
+		header.Op < OpAnnounce || header.Op > OpQuery {
+		return ErrInvalidMessage
+	}
+	m.Op = header.Op
+	m.TTL = time.Duration(header.TTL) * time.Millisecond
+
+	var err error
+	if m.Service.Name, err = readString8(r); err != nil {
+		return ErrInvalidMessage
+	}
+	if m.Service.Addr, err = readString8(r); err != nil {
+		return ErrInvalidMessage
+	}
+	count, err := r.ReadByte()
+	if err != nil {
+		return ErrInvalidMessage
+	}
+	m.Service.Meta = nil
+	if count > 0 {
+		m.Service.Meta = make(map[string]string, count)
+	}
+	for i := 0; i < int(count); i++ {
+		k, err := readString8(r)
+		if err != nil {
+			return ErrInvalidMessage
+		}
+		var size uint16
+		if err = binary.Read(r, binary.BigEndian, &size); err != nil {
+			return ErrInvalidMessage
+		}
+		v := make([]byte, size)
+		if _, err = io.ReadFull(r, v); err != nil {
+			return ErrInvalidMessage
+		}
+		m.Service.Meta[k] = string(v)
+	}
+	if r.Len() != 0 {
+		return ErrInvalidMessage
+	}
+	return nil
+}
+
+func readString8(r *bytes.Reader) (string, error) {
+	size, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, size)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
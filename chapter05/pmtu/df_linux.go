@@ -0,0 +1,84 @@
+package pmtu
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetDontFragment sets the don't-fragment bit on every datagram sent on conn.
+// With probe set the kernel ignores its cached path MTU, so datagrams larger
+// than it can still be sent as probes; otherwise writes larger than the known
+// path MTU fail with EMSGSIZE.
+func SetDontFragment(conn *net.UDPConn, probe bool) error {
+	v4, v6 := unix.IP_PMTUDISC_DO, unix.IPV6_PMTUDISC_DO
+	if probe {
+		v4, v6 = unix.IP_PMTUDISC_PROBE, unix.IPV6_PMTUDISC_PROBE
+	}
+
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sErr error
+	err = rc.Control(func(fd uintptr) {
+		if isIPv4(conn) {
+			sErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, v4)
+			return
+		}
+		sErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, v6)
+		if sErr == nil {
+			// dual-stack sockets send IPv4 datagrams too; a socket
+			// restricted to IPv6 rejects this, which is fine
+			_ = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, v4)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if sErr != nil {
+		return fmt.Errorf("setting don't-fragment: %w", sErr)
+	}
+	return nil
+}
+
+// KernelPathMTU returns the path MTU the kernel currently assumes for a
+// connected socket's peer, including IP and UDP headers.
+func KernelPathMTU(conn *net.UDPConn) (int, error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		mtu  int
+		sErr error
+	)
+	err = rc.Control(func(fd uintptr) {
+		if isIPv4(conn) {
+			mtu, sErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU)
+			return
+		}
+		mtu, sErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if sErr != nil {
+		return 0, fmt.Errorf("reading path MTU: %w", sErr)
+	}
+	return mtu, nil
+}
+
+// ClearPendingError discards the socket's pending error. Linux queues a copy
+// of a local EMSGSIZE on the socket, which would otherwise fail the next
+// write as well, so call it after a write fails with EMSGSIZE.
+func ClearPendingError(conn *net.UDPConn) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return
+	}
+	_ = rc.Control(func(fd uintptr) {
+		_, _ = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ERROR)
+	})
+}
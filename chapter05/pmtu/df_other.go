@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package pmtu
+
+import (
+	"errors"
+	"net"
+)
+
+var errUnsupported = errors.New("don't-fragment control is only supported on linux")
+
+func SetDontFragment(*net.UDPConn, bool) error { return errUnsupported }
+
+func KernelPathMTU(*net.UDPConn) (int, error) { return 0, errUnsupported }
+
+func ClearPendingError(*net.UDPConn) {}
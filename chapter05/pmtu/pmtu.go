@@ -0,0 +1,266 @@
+// Package pmtu finds the largest UDP payload that reaches a peer without IP
+// fragmentation, probing with the don't-fragment bit set in the style of
+// RFC 8899. Any peer that echoes datagrams can answer the probes.
+package pmtu
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// BasePayload is the payload size assumed to work on any path, the
+	// BASE_PLPMTU recommended by RFC 8899.
+	BasePayload = 1200
+	// MaxPayloadIPv4 and MaxPayloadIPv6 are the largest UDP payloads the IP
+	// length fields allow.
+	MaxPayloadIPv4 = 65535 - 20 - 8
+	MaxPayloadIPv6 = 65535 - 8
+
+	defaultTimeout   = 500 * time.Millisecond
+	defaultMaxProbes = 3 // MAX_PROBES in RFC 8899
+	probeHeaderSize  = 8
+)
+
+var (
+	probeMagic = []byte("PLPM")
+
+	ErrNoReply = errors.New("peer didn't echo base size probes")
+)
+
+// IsMessageTooLong reports whether err means a datagram was larger than the
+// path MTU known to the kernel. Writes fail that way once the don't-fragment
+// bit is set.
+func IsMessageTooLong(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE)
+}
+
+func isIPv4(conn net.Conn) bool {
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	return ok && addr.IP.To4() != nil
+}
+
+// Result is the outcome of probing a single peer.
+type Result struct {
+	Payload int // largest UDP payload that reached the peer
+	Probed  time.Time
+}
+
+// Prober searches for the largest payload each peer can receive and caches
+// the result by peer address.
+type Prober struct {
+	Network   string        // "udp", "udp4" or "udp6"; defaults to "udp"
+	Min       int           // payload assumed to work, at least 8 bytes; defaults to BasePayload
+	Max       int           // largest payload probed; defaults to the protocol maximum
+	Timeout   time.Duration // time to wait for each probe's echo; defaults to 500ms
+	MaxProbes int           // attempts before a size counts as too big; defaults to 3
+
+	mu      sync.Mutex
+	results map[string]Result
+}
+
+// Probe runs a binary search between Min and Max against the echoing peer
+// at addr and returns the largest payload size that made the round trip.
+// Sizes the local stack rejects with EMSGSIZE count as too big right away.
+func (p *Prober) Probe(ctx context.Context, addr string) (int, error) {
+	// the defaults stay local, since Probe may run concurrently
+	network := p.Network
+	if network == "" {
+		network = "udp"
+	}
+	min := p.min()
+	if min < probeHeaderSize {
+		return 0, fmt.Errorf("min payload %d is less than the %d byte probe header", min, probeHeaderSize)
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	maxProbes := p.MaxProbes
+	if maxProbes <= 0 {
+		maxProbes = defaultMaxProbes
+	}
+
+	var d net.Dialer
+	c, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	conn, ok := c.(*net.UDPConn)
+	if !ok {
+		return 0, fmt.Errorf("network %q isn't UDP", network)
+	}
+
+	max := p.Max
+	if max <= 0 {
+		max = MaxPayloadIPv6
+		if isIPv4(conn) {
+			max = MaxPayloadIPv4
+		}
+	}
+	if max < min {
+		return 0, fmt.Errorf("max payload %d is less than min payload %d", max, min)
+	}
+
+	if err = SetDontFragment(conn, true); err != nil {
+		return 0, err
+	}
+
+	s := &session{conn: conn, timeout: timeout, maxProbes: maxProbes, buf: make([]byte, max+1)}
+	ok, err = s.probe(ctx, min)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", addr, ErrNoReply)
+	}
+
+	low, high := min, max
+	for low < high {
+		mid := low + (high-low+1)/2
+		ok, err = s.probe(ctx, mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+
+	p.store(addr, Result{Payload: low, Probed: time.Now()})
+	return low, nil
+}
+
+// PayloadSize returns the probed payload size for addr, or Min if addr
+// hasn't been probed. A TFTP server can cap the block size it negotiates at
+// this size minus the 4 byte DATA header.
+func (p *Prober) PayloadSize(addr string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if r, ok := p.results[addr]; ok {
+		return r.Payload
+	}
+	return p.min()
+}
+
+func (p *Prober) min() int {
+	if p.Min == 0 {
+		return BasePayload
+	}
+	return p.Min
+}
+
+// Lower records that a payload of size bytes failed to reach addr, for
+// example because a write returned EMSGSIZE after the route changed. The
+// cached size drops below it until the next Probe.
+func (p *Prober) Lower(addr string, size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if r, ok := p.results[addr]; ok && r.Payload >= size {
+		r.Payload = size - 1
+		p.results[addr] = r
+	}
+}
+
+// Results returns a copy of the cached results keyed by peer address.
+func (p *Prober) Results() map[string]Result {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r := make(map[string]Result, len(p.results))
+	for k, v := range p.results {
+		r[k] = v
+	}
+	return r
+}
+
+func (p *Prober) store(addr string, r Result) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.results == nil {
+		p.results = make(map[string]Result)
+	}
+	p.results[addr] = r
+}
+
+// session holds the state of one Probe call.
+type session struct {
+	conn      *net.UDPConn
+	timeout   time.Duration
+	maxProbes int
+	seq       uint32
+	buf       []byte
+}
+
+// probe reports whether a payload of size bytes makes the round trip to the
+// peer within maxProbes attempts.
+func (s *session) probe(ctx context.Context, size int) (bool, error) {
+	probe := make([]byte, size)
+	copy(probe, probeMagic)
+
+	for i := 0; i < s.maxProbes; i++ {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		s.seq++
+		binary.BigEndian.PutUint32(probe[4:probeHeaderSize], s.seq)
+
+		_, err := s.conn.Write(probe)
+		if IsMessageTooLong(err) {
+			// the local interface or the kernel's path MTU rules it out
+			ClearPendingError(s.conn)
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		deadline := time.Now().Add(s.timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err = s.conn.SetReadDeadline(deadline); err != nil {
+			return false, err
+		}
+
+		ok, err := s.awaitEcho(probe)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// awaitEcho reads until the echo of probe arrives or the read deadline
+// passes. Echoes of earlier probes that arrive late are skipped.
+func (s *session) awaitEcho(probe []byte) (bool, error) {
+	for {
+		n, err := s.conn.Read(s.buf)
+		if err != nil {
+			var nErr net.Error
+			if errors.As(err, &nErr) && nErr.Timeout() {
+				return false, nil
+			}
+			return false, err
+		}
+		if n == len(probe) && bytes.Equal(s.buf[:probeHeaderSize], probe[:probeHeaderSize]) {
+			return true, nil
+		}
+	}
+}
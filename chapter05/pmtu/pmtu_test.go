@@ -0,0 +1,184 @@
+package pmtu
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echo echoes datagrams no larger than limit and silently drops the rest,
+// like a path whose MTU is smaller than the local interface's.
+func echo(t *testing.T, ctx context.Context, limit int) net.Addr {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	go func() {
+		buf := make([]byte, MaxPayloadIPv4+1)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n > limit {
+				continue
+			}
+			_, _ = conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr()
+}
+
+func TestProbe(t *testing.T) {
+	testCases := []struct {
+		limit    int // largest datagram the peer answers
+		max      int // largest size probed
+		expected int
+	}{
+		{limit: 1400, max: 9000, expected: 1400}, // a black hole above 1400 bytes
+		{limit: MaxPayloadIPv4, max: 9000, expected: 9000},
+		// the loopback interface accepts anything the IPv4 length field
+		// allows; anything larger fails locally with EMSGSIZE
+		{limit: MaxPayloadIPv4, max: 70000, expected: MaxPayloadIPv4},
+	}
+
+	for i, c := range testCases {
+		ctx, cancel := context.WithCancel(context.Background())
+		addr := echo(t, ctx, c.limit)
+
+		p := &Prober{Max: c.max, Timeout: 50 * time.Millisecond, MaxProbes: 2}
+		actual, err := p.Probe(ctx, addr.String())
+		cancel()
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if actual != c.expected {
+			t.Errorf("%d: expected payload %d; actual %d", i, c.expected, actual)
+		}
+		if size := p.PayloadSize(addr.String()); size != c.expected {
+			t.Errorf("%d: expected cached payload %d; actual %d", i, c.expected, size)
+		}
+	}
+}
+
+func TestProbeConcurrent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Network, Min and MaxProbes keep their defaults
+	p := &Prober{Max: 2000, Timeout: 20 * time.Millisecond}
+	limits := []int{1400, 1500, 2000}
+	addrs := make([]string, len(limits))
+	for i, limit := range limits {
+		addrs[i] = echo(t, ctx, limit).String()
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(addrs))
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			_, errs[i] = p.Probe(ctx, addr)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	for i, addr := range addrs {
+		if errs[i] != nil {
+			t.Fatalf("%s: %v", addr, errs[i])
+		}
+		if size := p.PayloadSize(addr); size != limits[i] {
+			t.Errorf("%s: expected payload %d; actual %d", addr, limits[i], size)
+		}
+	}
+	if p.Network != "" || p.Min != 0 || p.MaxProbes != 0 {
+		t.Errorf("Probe changed the configuration: %+v", p)
+	}
+}
+
+func TestProbeNoReply(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr := echo(t, ctx, 100)
+
+	p := &Prober{Timeout: 20 * time.Millisecond, MaxProbes: 1}
+	_, err := p.Probe(ctx, addr.String())
+	if !errors.Is(err, ErrNoReply) {
+		t.Fatalf("expected %v; actual %v", ErrNoReply, err)
+	}
+	if size := p.PayloadSize(addr.String()); size != BasePayload {
+		t.Errorf("expected payload %d for unprobed peer; actual %d", BasePayload, size)
+	}
+}
+
+func TestProbeMin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr := echo(t, ctx, 100)
+
+	p := &Prober{Min: probeHeaderSize, Max: 200, Timeout: 20 * time.Millisecond}
+	actual, err := p.Probe(ctx, addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != 100 {
+		t.Errorf("expected payload %d; actual %d", 100, actual)
+	}
+
+	// rejected before probing, rather than probing from BasePayload
+	p = &Prober{Min: 4, Max: 2000, Timeout: 20 * time.Millisecond, MaxProbes: 1}
+	if _, err = p.Probe(ctx, addr.String()); err == nil || errors.Is(err, ErrNoReply) {
+		t.Errorf("expected an error for a min payload below the probe header; actual %v", err)
+	}
+}
+
+func TestProbeNetwork(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	p := &Prober{Network: "tcp"}
+	if _, err = p.Probe(context.Background(), l.Addr().String()); err == nil {
+		t.Error("expected an error for a network other than UDP")
+	}
+}
+
+func TestLower(t *testing.T) {
+	p := new(Prober)
+	p.store("peer", Result{Payload: 1472})
+
+	p.Lower("peer", 1500) // larger than the cached size: no change
+	if size := p.PayloadSize("peer"); size != 1472 {
+		t.Fatalf("expected %d; actual %d", 1472, size)
+	}
+	p.Lower("peer", 1400)
+	if size := p.PayloadSize("peer"); size != 1399 {
+		t.Fatalf("expected %d; actual %d", 1399, size)
+	}
+}
+
+func TestDontFragment(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err = SetDontFragment(conn, false); err != nil {
+		t.Skip(err)
+	}
+	_, err = conn.WriteTo(make([]byte, MaxPayloadIPv4+1), conn.LocalAddr())
+	if !IsMessageTooLong(err) {
+		t.Fatalf("expected EMSGSIZE; actual %v", err)
+	}
+}
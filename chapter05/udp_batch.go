@@ -0,0 +1,108 @@
+package chapter05
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// batchConn reads and writes many datagrams per system call. On Linux
+// ReadBatch and WriteBatch use recvmmsg(2) and sendmmsg(2); elsewhere they
+// move a single datagram per call. ipv4.Message and ipv6.Message are the
+// same type, so one interface covers both address families.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// newBatchConn returns a batchConn for UDP sockets, picking the ipv4 or ipv6
+// package by the socket's address family.
+func newBatchConn(conn net.PacketConn) (batchConn, bool) {
+	c, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil, false
+	}
+	addr, ok := c.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, false
+	}
+	if addr.IP.To4() != nil {
+		return ipv4.NewPacketConn(c), true
+	}
+	return ipv6.NewPacketConn(c), true
+}
+
+// serveBatch echoes datagrams BatchSize at a time. It returns errBatchUnsupported
+// if the very first batch read fails so serveWorker can fall back to reading one
+// datagram at a time.
+func (s *UDPEchoServer) serveBatch(ctx context.Context, conn net.PacketConn, bc batchConn, cfg settings) error {
+	reads := make([]ipv4.Message, cfg.batchSize)
+	for i := range reads {
+		// see serve for the extra byte
+		reads[i].Buffers = [][]byte{make([]byte, cfg.maxSize+1)}
+	}
+	writes := make([]ipv4.Message, cfg.batchSize)
+	for i := range writes {
+		writes[i].Buffers = make([][]byte, 1)
+	}
+
+	for first := true; ; first = false {
+		n, err := bc.ReadBatch(reads, 0)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var nErr net.Error
+			if errors.As(err, &nErr) && nErr.Timeout() {
+				continue
+			}
+			if first {
+				return fmt.Errorf("%w: %v", errBatchUnsupported, err)
+			}
+			return fmt.Errorf("read batch: %w", err)
+		}
+		atomic.AddUint64(&s.stats.Received, uint64(n))
+
+		out := writes[:0]
+		for _, m := range reads[:n] {
+			if m.N > cfg.maxSize {
+				atomic.AddUint64(&s.stats.Truncated, 1)
+				s.record(cfg, m.Addr, func(p *PeerStats) { p.Received++; p.Truncated++ })
+				cfg.logger.Printf("[%s] dropped datagram larger than %d bytes", m.Addr, cfg.maxSize)
+				continue
+			}
+			s.record(cfg, m.Addr, func(p *PeerStats) { p.Received++ })
+			i := len(out)
+			out = out[:i+1]
+			out[i].Buffers[0] = m.Buffers[0][:m.N]
+			out[i].Addr = m.Addr
+		}
+
+		for len(out) > 0 {
+			sent, err := bc.WriteBatch(out, 0)
+			for _, m := range out[:sent] {
+				size := uint64(len(m.Buffers[0]))
+				atomic.AddUint64(&s.stats.Sent, 1)
+				atomic.AddUint64(&s.stats.Bytes, size)
+				s.record(cfg, m.Addr, func(p *PeerStats) { p.Sent++; p.Bytes += size })
+			}
+			out = out[sent:]
+			if err != nil && len(out) > 0 {
+				if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+					return nil
+				}
+				// skip the datagram that failed and send the rest
+				atomic.AddUint64(&s.stats.WriteErrors, 1)
+				s.writeFailed(cfg, conn, out[0].Addr, len(out[0].Buffers[0]), err)
+				out = out[1:]
+			}
+		}
+	}
+}
+
+var errBatchUnsupported = errors.New("batch I/O unsupported")
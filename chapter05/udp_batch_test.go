@@ -0,0 +1,149 @@
+package chapter05
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUDPEchoServerBatch(t *testing.T) {
+	logs := new(bytes.Buffer)
+	s := &UDPEchoServer{MaxDatagramSize: 512, BatchSize: 8,
+		Logger: log.New(logs, "", 0)}
+	conn, err := s.Listen("127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Serve(ctx, conn) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var expected [][]byte
+	for i := 0; i < 20; i++ {
+		msg := bytes.Repeat([]byte{byte('a' + i)}, 16*(i+1))
+		if _, err = client.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		if len(msg) <= s.MaxDatagramSize {
+			expected = append(expected, msg)
+		}
+	}
+
+	buf := make([]byte, 1<<10)
+	for i, msg := range expected {
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !bytes.Equal(msg, buf[:n]) {
+			t.Fatalf("%d: expected %d bytes of %q; actual %d bytes", i, len(msg), msg[0], n)
+		}
+	}
+
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logs.String(), "falling back") {
+		t.Errorf("unexpected fallback: %s", logs)
+	}
+	stats := s.Stats()
+	if stats.Received != 20 || stats.Sent != uint64(len(expected)) ||
+		stats.Truncated != uint64(20-len(expected)) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestUDPEchoServerBatchFallback(t *testing.T) {
+	s := &UDPEchoServer{BatchSize: 8}
+	conn, err := s.Listen("127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// hiding the *net.UDPConn leaves only the per-datagram path
+	go func() { _ = s.Serve(ctx, struct{ net.PacketConn }{conn}) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err = client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := string(buf[:n]); actual != "ping" {
+		t.Fatalf("expected %q; actual %q", "ping", actual)
+	}
+}
+
+// benchmarkEcho measures echoed packets per second. The client keeps a window
+// of datagrams in flight so the server, not the round trip, is the bottleneck.
+func benchmarkEcho(b *testing.B, s *UDPEchoServer) {
+	conn, err := s.Listen("127.0.0.1:")
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = s.Serve(ctx, conn) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+
+	const window = 32
+	msg := bytes.Repeat([]byte("x"), 64)
+	buf := make([]byte, 1<<10)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for sent := 0; sent < b.N; sent += window {
+		batch := window
+		if b.N-sent < batch {
+			batch = b.N - sent
+		}
+		for i := 0; i < batch; i++ {
+			if _, err = client.Write(msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for i := 0; i < batch; i++ {
+			_ = client.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err = client.Read(buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "pkts/s")
+}
+
+func BenchmarkUDPEchoPerPacket(b *testing.B) {
+	benchmarkEcho(b, new(UDPEchoServer))
+}
+
+func BenchmarkUDPEchoBatch(b *testing.B) {
+	benchmarkEcho(b, &UDPEchoServer{BatchSize: 32})
+}
@@ -2,44 +2,331 @@ package chapter05
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"go-network/chapter05/pmtu"
 )
 
-func echoServerUDP(ctx context.Context, addr string) (net.Addr, error) {
-	s, err := net.ListenPacket("udp", addr)
+const (
+	DefaultMaxDatagramSize = 1 << 10
+	MaxUDPPayload          = 65507 // 65535 - 8 byte UDP header - 20 byte IP header
+	defaultMaxPeers        = 1024
+)
+
+// UDPEchoStats counts datagrams across all peers.
+type UDPEchoStats struct {
+	Received    uint64 // datagrams read
+	Sent        uint64 // datagrams echoed
+	Bytes       uint64 // payload bytes echoed
+	Truncated   uint64 // datagrams dropped for exceeding MaxDatagramSize
+	WriteErrors uint64 // echoes that failed
+}
+
+// PeerStats counts datagrams for a single remote address.
+type PeerStats struct {
+	Received  uint64
+	Sent      uint64
+	Bytes     uint64
+	Truncated uint64
+	LastSeen  time.Time
+}
+
+// UDPEchoServer echoes every datagram back to its sender. Datagrams larger
+// than MaxDatagramSize are counted as truncated and dropped instead of being
+// echoed partially, and a failed write to one peer doesn't stop the server.
+type UDPEchoServer struct {
+	// stats comes first so its counters stay 64-bit aligned for atomic
+	// access on 32-bit platforms
+	stats UDPEchoStats
+
+	Network         string      // "udp", "udp4" or "udp6"; defaults to "udp"
+	MaxDatagramSize int         // largest datagram echoed; defaults to 1 KB
+	Workers         int         // goroutines reading from the socket; defaults to 1
+	BatchSize       int         // datagrams per read and write system call; 0 or 1 disables batching
+	ReadBuffer      int         // SO_RCVBUF size in bytes; zero keeps the OS default
+	WriteBuffer     int         // SO_SNDBUF size in bytes; zero keeps the OS default
+	DontFragment    bool        // set the don't-fragment bit on echoes (Linux only)
+	MaxPeers        int         // peers tracked for statistics; defaults to 1024
+	Logger          *log.Logger // defaults to discarding log output
+
+	mu    sync.Mutex
+	peers map[string]*PeerStats
+}
+
+// ListenAndServe binds addr and echoes datagrams until ctx is canceled.
+func (s *UDPEchoServer) ListenAndServe(ctx context.Context, addr string) error {
+	conn, err := s.Listen(addr)
 	if err != nil {
-		return nil, fmt.Errorf("binding to udp: %s: %w", addr, err)
+		return err
 	}
+	return s.Serve(ctx, conn)
+}
+
+// Listen binds addr on the server's network. Use it with Serve when the
+// caller needs the bound address before serving, e.g. for port 0.
+func (s *UDPEchoServer) Listen(addr string) (net.PacketConn, error) {
+	network := s.Network
+	if network == "" {
+		network = "udp"
+	}
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("binding to %s: %s: %w", network, addr, err)
+	}
+	return conn, nil
+}
 
+// Serve echoes datagrams read from conn until ctx is canceled, at which point
+// it closes conn and returns nil.
+func (s *UDPEchoServer) Serve(ctx context.Context, conn net.PacketConn) error {
+	if conn == nil {
+		return errors.New("nil connection")
+	}
+	cfg := s.settings()
+
+	if err := s.setSocketOptions(conn); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
-		go func() {
-			// should be canceled
-			<-ctx.Done()
-			_ = s.Close()
-		}()
-
-		buf := make([]byte, 1024)
-		for {
-			// client to server
-			n, clientAddr, err := s.ReadFrom(buf)
-			//fmt.Println("clientAddr", clientAddr.String())
-			if err != nil {
-				fmt.Printf("server read error: %v\n", err)
-				return
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done: // a worker failed and closed conn
+		}
+	}()
+
+	errs := make(chan error, cfg.workers)
+	for i := 0; i < cfg.workers; i++ {
+		go func() { errs <- s.serveWorker(ctx, conn, cfg) }()
+	}
+
+	var err error
+	for i := 0; i < cfg.workers; i++ {
+		if wErr := <-errs; wErr != nil && err == nil {
+			err = wErr
+			// stop the remaining workers
+			_ = conn.Close()
+		}
+	}
+	return err
+}
+
+// serveWorker uses batch I/O when it's enabled and available, and falls back
+// to one datagram per system call otherwise.
+func (s *UDPEchoServer) serveWorker(ctx context.Context, conn net.PacketConn, cfg settings) error {
+	if cfg.batchSize > 1 {
+		if bc, ok := newBatchConn(conn); ok {
+			err := s.serveBatch(ctx, conn, bc, cfg)
+			if !errors.Is(err, errBatchUnsupported) {
+				return err
+			}
+			cfg.logger.Printf("falling back to per-datagram I/O: %v", err)
+		}
+	}
+	return s.serve(ctx, conn, cfg)
+}
+
+// settings are the server's fields with their defaults applied. Serve
+// resolves them once rather than writing them to s, which other goroutines
+// may be reading.
+type settings struct {
+	maxSize   int
+	workers   int
+	batchSize int
+	maxPeers  int
+	logger    *log.Logger
+}
+
+func (s *UDPEchoServer) settings() settings {
+	cfg := settings{
+		maxSize:   s.MaxDatagramSize,
+		workers:   s.Workers,
+		batchSize: s.BatchSize,
+		maxPeers:  s.MaxPeers,
+		logger:    s.Logger,
+	}
+	if cfg.maxSize <= 0 {
+		cfg.maxSize = DefaultMaxDatagramSize
+	}
+	if cfg.maxSize > MaxUDPPayload {
+		cfg.maxSize = MaxUDPPayload
+	}
+	if cfg.workers <= 0 {
+		cfg.workers = 1
+	}
+	if cfg.maxPeers <= 0 {
+		cfg.maxPeers = defaultMaxPeers
+	}
+	if cfg.logger == nil {
+		cfg.logger = log.New(ioutil.Discard, "", 0)
+	}
+	s.mu.Lock()
+	if s.peers == nil {
+		s.peers = make(map[string]*PeerStats)
+	}
+	s.mu.Unlock()
+	return cfg
+}
+
+func (s *UDPEchoServer) setSocketOptions(conn net.PacketConn) error {
+	uc, ok := conn.(*net.UDPConn)
+	if !ok {
+		if s.ReadBuffer > 0 || s.WriteBuffer > 0 || s.DontFragment {
+			return fmt.Errorf("%T doesn't support socket options", conn)
+		}
+		return nil
+	}
+	if s.DontFragment {
+		if err := pmtu.SetDontFragment(uc, false); err != nil {
+			return err
+		}
+	}
+	if s.ReadBuffer > 0 {
+		if err := uc.SetReadBuffer(s.ReadBuffer); err != nil {
+			return fmt.Errorf("setting SO_RCVBUF: %w", err)
+		}
+	}
+	if s.WriteBuffer > 0 {
+		if err := uc.SetWriteBuffer(s.WriteBuffer); err != nil {
+			return fmt.Errorf("setting SO_SNDBUF: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *UDPEchoServer) serve(ctx context.Context, conn net.PacketConn, cfg settings) error {
+	// one extra byte tells a datagram that exactly fills the buffer apart
+	// from one the kernel truncated to fit it
+	buf := make([]byte, cfg.maxSize+1)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var nErr net.Error
+			if errors.As(err, &nErr) && nErr.Timeout() {
+				continue
 			}
+			return fmt.Errorf("read: %w", err)
+		}
+		atomic.AddUint64(&s.stats.Received, 1)
+
+		if n > cfg.maxSize {
+			atomic.AddUint64(&s.stats.Truncated, 1)
+			s.record(cfg, addr, func(p *PeerStats) { p.Received++; p.Truncated++ })
+			cfg.logger.Printf("[%s] dropped datagram larger than %d bytes", addr, cfg.maxSize)
+			continue
+		}
+		s.record(cfg, addr, func(p *PeerStats) { p.Received++ })
 
-			// server to client
-			_, err = s.WriteTo(buf[:n], clientAddr)
-			if err != nil {
-				fmt.Printf("server write error: %v\n", err)
-				return
+		_, err = conn.WriteTo(buf[:n], addr)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
 			}
+			atomic.AddUint64(&s.stats.WriteErrors, 1)
+			s.writeFailed(cfg, conn, addr, n, err)
+			continue
+		}
+		atomic.AddUint64(&s.stats.Sent, 1)
+		atomic.AddUint64(&s.stats.Bytes, uint64(n))
+		s.record(cfg, addr, func(p *PeerStats) { p.Sent++; p.Bytes += uint64(n) })
+	}
+}
+
+func (s *UDPEchoServer) writeFailed(cfg settings, conn net.PacketConn, addr net.Addr, size int, err error) {
+	if pmtu.IsMessageTooLong(err) {
+		if uc, ok := conn.(*net.UDPConn); ok {
+			pmtu.ClearPendingError(uc)
+		}
+		cfg.logger.Printf("[%s] %d byte echo exceeds the path MTU", addr, size)
+		return
+	}
+	cfg.logger.Printf("[%s] write: %v", addr, err)
+}
+
+func (s *UDPEchoServer) record(cfg settings, addr net.Addr, update func(*PeerStats)) {
+	key := addr.String()
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.peers[key]
+	if !ok {
+		if len(s.peers) >= cfg.maxPeers {
+			s.evictOldestPeer()
+		}
+		p = new(PeerStats)
+		s.peers[key] = p
+	}
+	update(p)
+	p.LastSeen = now
+}
+
+// evictOldestPeer must be called with s.mu held.
+func (s *UDPEchoServer) evictOldestPeer() {
+	var (
+		oldest string
+		seen   time.Time
+	)
+	for k, p := range s.peers {
+		if oldest == "" || p.LastSeen.Before(seen) {
+			oldest, seen = k, p.LastSeen
+		}
+	}
+	delete(s.peers, oldest)
+}
+
+// Stats returns a snapshot of the server's counters.
+func (s *UDPEchoServer) Stats() UDPEchoStats {
+	return UDPEchoStats{
+		Received:    atomic.LoadUint64(&s.stats.Received),
+		Sent:        atomic.LoadUint64(&s.stats.Sent),
+		Bytes:       atomic.LoadUint64(&s.stats.Bytes),
+		Truncated:   atomic.LoadUint64(&s.stats.Truncated),
+		WriteErrors: atomic.LoadUint64(&s.stats.WriteErrors),
+	}
+}
+
+// Peers returns a snapshot of the per-peer counters keyed by remote address.
+func (s *UDPEchoServer) Peers() map[string]PeerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := make(map[string]PeerStats, len(s.peers))
+	for k, p := range s.peers {
+		peers[k] = *p
+	}
+	return peers
+}
+
+func echoServerUDP(ctx context.Context, addr string) (net.Addr, error) {
+	s := new(UDPEchoServer)
+	conn, err := s.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		if err := s.Serve(ctx, conn); err != nil {
+			log.Printf("echo server: %v", err)
 		}
 	}()
 
-	return s.LocalAddr(), nil
+	return conn.LocalAddr(), nil
 }
 
 func main() {
@@ -3,8 +3,13 @@ package chapter05
 import (
 	"bytes"
 	"context"
+	"errors"
 	"net"
+	"runtime"
 	"testing"
+	"time"
+
+	"go-network/chapter05/pmtu"
 )
 
 func TestEchoServerUDP(t *testing.T) {
@@ -45,3 +50,208 @@ func TestEchoServerUDP(t *testing.T) {
 	}
 	//time.Sleep(10 * time.Second)
 }
+
+func TestUDPEchoServerTruncation(t *testing.T) {
+	for _, network := range []string{"udp4", "udp6"} {
+		network := network
+		t.Run(network, func(t *testing.T) {
+			addr := "127.0.0.1:"
+			if network == "udp6" {
+				addr = "[::1]:"
+			}
+			s := &UDPEchoServer{
+				Network:         network,
+				MaxDatagramSize: 2 << 10,
+				Workers:         4,
+				ReadBuffer:      64 << 10,
+				WriteBuffer:     64 << 10,
+			}
+			conn, err := s.Listen(addr)
+			if err != nil {
+				t.Skip(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- s.Serve(ctx, conn) }()
+
+			client, err := net.Dial(network, conn.LocalAddr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			// larger than the old fixed 1 KB buffer, but within the limit
+			large := bytes.Repeat([]byte("a"), 2<<10)
+			tooLarge := bytes.Repeat([]byte("b"), 2<<10+1)
+			buf := make([]byte, 4<<10)
+
+			for _, msg := range [][]byte{large, tooLarge, []byte("ping")} {
+				if _, err = client.Write(msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// the oversized datagram is dropped rather than echoed partially
+			for _, expected := range [][]byte{large, []byte("ping")} {
+				_ = client.SetReadDeadline(time.Now().Add(time.Second))
+				n, err := client.Read(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(expected, buf[:n]) {
+					t.Fatalf("expected %d byte reply; actual %d bytes", len(expected), n)
+				}
+			}
+
+			stats := s.Stats()
+			if stats.Received != 3 || stats.Sent != 2 || stats.Truncated != 1 {
+				t.Errorf("unexpected stats: %+v", stats)
+			}
+			peer, ok := s.Peers()[client.LocalAddr().String()]
+			if !ok {
+				t.Fatalf("no stats for %s", client.LocalAddr())
+			}
+			if peer.Received != 3 || peer.Sent != 2 || peer.Truncated != 1 ||
+				peer.Bytes != uint64(len(large)+4) {
+				t.Errorf("unexpected peer stats: %+v", peer)
+			}
+
+			cancel()
+			if err = <-done; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUDPEchoServerMaxPeers(t *testing.T) {
+	s := &UDPEchoServer{MaxPeers: 2}
+	conn, err := s.Listen("127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = s.Serve(ctx, conn) }()
+
+	buf := make([]byte, 64)
+	for i := 0; i < 3; i++ {
+		client, err := net.Dial("udp", conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err = client.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+		_ = client.Close()
+	}
+
+	if peers := s.Peers(); len(peers) != 2 {
+		t.Errorf("expected 2 tracked peers; actual %d", len(peers))
+	}
+}
+
+func TestUDPEchoServerPathMTU(t *testing.T) {
+	testCases := []struct {
+		maxDatagramSize int
+		expected        int
+	}{
+		{maxDatagramSize: 9000, expected: 9000},
+		// the server drops larger datagrams, which looks like a path MTU
+		// black hole to the prober
+		{maxDatagramSize: 1400, expected: 1400},
+	}
+
+	for i, c := range testCases {
+		s := &UDPEchoServer{MaxDatagramSize: c.maxDatagramSize, DontFragment: true}
+		conn, err := s.Listen("127.0.0.1:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- s.Serve(ctx, conn) }()
+
+		p := &pmtu.Prober{Max: 9000, Timeout: 50 * time.Millisecond, MaxProbes: 2}
+		actual, err := p.Probe(ctx, conn.LocalAddr().String())
+		cancel()
+		if sErr := <-done; sErr != nil {
+			t.Skip(sErr) // no don't-fragment support on this platform
+		}
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if actual != c.expected {
+			t.Errorf("%d: expected payload %d; actual %d", i, c.expected, actual)
+		}
+	}
+}
+
+func TestUDPEchoServerShared(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// every setting keeps its default
+	s := new(UDPEchoServer)
+	var addrs []net.Addr
+	for i := 0; i < 2; i++ {
+		conn, err := s.Listen("127.0.0.1:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, conn.LocalAddr())
+		go func() { _ = s.Serve(ctx, conn) }()
+	}
+
+	buf := make([]byte, 64)
+	for _, addr := range addrs {
+		client, err := net.Dial("udp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err = client.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+		_ = client.Close()
+	}
+	if s.Network != "" || s.MaxDatagramSize != 0 || s.Workers != 0 ||
+		s.MaxPeers != 0 || s.Logger != nil {
+		t.Errorf("Serve changed the configuration: %+v", s)
+	}
+}
+
+// failingConn fails every read.
+type failingConn struct{ net.PacketConn }
+
+func (failingConn) ReadFrom([]byte) (int, net.Addr, error) {
+	return 0, nil, errors.New("read failed")
+}
+
+func TestUDPEchoServerWorkerError(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+
+	// ctx is never canceled
+	s := new(UDPEchoServer)
+	if err = s.Serve(context.Background(), failingConn{conn}); err == nil {
+		t.Fatal("expected the worker's error")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Serve left %d goroutines behind", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,49 +1,59 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"go-network/chapter07"
 	"go-network/chapter07/creds/auth"
 	"log"
 	"net"
 	"os"
 	"os/signal"
-	"os/user"
 	"path/filepath"
+	"strings"
+	"time"
+)
+
+type stringList []string
+
+func (s *stringList) String() string     { return strings.Join(*s, ",") }
+func (s *stringList) Set(v string) error { *s = append(*s, v); return nil }
+
+var (
+	users stringList
+	exes  stringList
 )
 
 func init() {
+	flag.Var(&users, "user", "allowed user name (repeatable)")
+	flag.Var(&exes, "exe", "allowed executable path (repeatable)")
 	flag.Usage = func() {
-		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n\t%s <group names>\n", filepath.Base(os.Args[0]))
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n\t%s [flags] <group names>\n", filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 	}
 }
 
-func parseGroupNames(args []string) map[string]struct{} {
-	groups := make(map[string]struct{})
-	for _, arg := range args {
-		grp, err := user.LookupGroup(arg)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
-		groups[grp.Gid] = struct{}{}
-	}
-	return groups
-}
-
 func main() {
 	flag.Parse()
 
-	groups := parseGroupNames(flag.Args())
-	log.Printf("groups: %#v\n", groups)
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	a := &auth.Authorizer{
+		Users:       users,
+		Groups:      flag.Args(),
+		Executables: exes,
+		Logger:      logger,
+	}
+	log.Printf("users: %q groups: %q executables: %q\n", a.Users, a.Groups, a.Executables)
+
 	socket := filepath.Join(os.TempDir(), "creds.sock")
-	addr, err := net.ResolveUnixAddr("unix", socket)
-	if err != nil {
-		log.Fatal(err)
+	s := &chapter07.UnixServer{
+		Addr:    socket,
+		Mode:    0666, // the Authorizer, not file permissions, decides who gets in
+		Handler: a.Handler(chapter07.HandlerFunc(welcome)),
+		Logger:  logger,
 	}
-	s, err := net.ListenUnix("unix", addr)
-	if err != nil {
+	if err := s.Listen(); err != nil {
 		log.Fatal(err)
 	}
 
@@ -51,27 +61,30 @@ func main() {
 	signal.Notify(c, os.Interrupt)
 	go func() {
 		<-c
-		s.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
 	}()
 	fmt.Printf("Listening on %s ...\n", socket)
 
-	for {
-		conn, err := s.AcceptUnix()
-		if err != nil {
-			break
-		}
-		if auth.Allowed(conn, groups) {
-			_, err = conn.Write([]byte("Welcome\n"))
-			if err == nil {
-				// handle the connection in a goroutine here
-				continue
-			}
-		} else {
-			_, err = conn.Write([]byte("Access denied\n"))
-		}
-		if err != nil {
-			log.Println(err)
-		}
-		_ = conn.Close()
+	if err := s.Serve(); err != chapter07.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// welcome greets an authorized peer by name and echoes what it sends until
+// either side hangs up.
+func welcome(ctx context.Context, conn net.Conn) {
+	id, _ := auth.FromContext(ctx)
+	name := fmt.Sprintf("uid %d", id.Uid)
+	if id.User != nil {
+		name = id.User.Username
+	}
+	if _, err := fmt.Fprintf(conn, "Welcome, %s (pid %d)\n", name, id.Pid); err != nil {
+		log.Println(err)
+		return
 	}
+	chapter07.EchoHandler().ServeConn(ctx, conn)
 }
@@ -0,0 +1,255 @@
+// Package auth decides whether the peer of a Unix domain socket connection may
+// use a service, based on the credentials the kernel reports for it.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var ErrDenied = errors.New("access denied")
+
+// Identity describes the process on the other end of a connection.
+type Identity struct {
+	unix.Ucred            // pid, uid and gid of the peer when it connected
+	User       *user.User // nil if the uid has no entry in the user database
+	GroupIDs   []string   // the user's group IDs, including the primary group
+	Exe        string     // the peer's executable; empty if it couldn't be read
+}
+
+// Authorizer allows a peer if it matches any of the configured policies. An
+// Authorizer without policies denies everyone.
+type Authorizer struct {
+	UIDs        []uint32 // allowed user IDs
+	Users       []string // allowed user names
+	Groups      []string // allowed group names or numeric group IDs
+	Executables []string // allowed executable paths, as /proc/<pid>/exe reports them
+
+	CacheTTL time.Duration // lifetime of cached lookups; defaults to DefaultCacheTTL
+	Logger   *log.Logger   // defaults to discarding log output
+
+	cache cache
+}
+
+// Allowed reports whether the peer of conn belongs to one of the groups,
+// given as a set of group IDs.
+func Allowed(conn *net.UnixConn, groups map[string]struct{}) bool {
+	a := new(Authorizer)
+	for gid := range groups {
+		a.Groups = append(a.Groups, gid)
+	}
+	_, err := a.Authorize(conn)
+	return err == nil
+}
+
+// Authorize identifies the peer of conn and checks it against the policies.
+// It returns the identity even if the peer is denied, in which case the
+// error wraps ErrDenied.
+func (a *Authorizer) Authorize(conn *net.UnixConn) (*Identity, error) {
+	ucred, err := PeerCred(conn)
+	if err != nil {
+		return nil, err
+	}
+	return a.AuthorizeCred(ucred)
+}
+
+// Identify returns the identity of the peer of conn. Failing to look up the
+// user or the executable isn't an error; the corresponding fields stay empty.
+func (a *Authorizer) Identify(conn *net.UnixConn) (*Identity, error) {
+	ucred, err := PeerCred(conn)
+	if err != nil {
+		return nil, err
+	}
+	return a.identify(ucred), nil
+}
+
+// AuthorizeCred checks credentials received with a datagram, for example by
+// CredPacketConn, against the policies. Like Authorize, it returns the
+// identity even if the peer is denied.
+func (a *Authorizer) AuthorizeCred(ucred *unix.Ucred) (*Identity, error) {
+	id := a.identify(ucred)
+	if !a.allowed(id) {
+		return id, fmt.Errorf("pid %d uid %d: %w", id.Pid, id.Uid, ErrDenied)
+	}
+	return id, nil
+}
+
+func (a *Authorizer) identify(ucred *unix.Ucred) *Identity {
+	var err error
+	id := &Identity{Ucred: *ucred}
+
+	if id.User, err = a.lookupUser(ucred.Uid); err != nil {
+		a.logger().Printf("looking up uid %d: %v", ucred.Uid, err)
+	} else if id.GroupIDs, err = a.groupIDs(id.User); err != nil {
+		a.logger().Printf("looking up groups of %s: %v", id.User.Username, err)
+	}
+	if id.GroupIDs == nil {
+		id.GroupIDs = []string{strconv.Itoa(int(ucred.Gid))}
+	}
+
+	if len(a.Executables) > 0 {
+		// The pid may be reused once the peer exits, so the path is only
+		// trustworthy while the peer is known to be alive, such as while
+		// its connection is open.
+		id.Exe, err = os.Readlink(fmt.Sprintf("/proc/%d/exe", ucred.Pid))
+		if err != nil {
+			a.logger().Printf("reading executable of pid %d: %v", ucred.Pid, err)
+		}
+	}
+
+	return id
+}
+
+// PeerCred returns the credentials of the peer of conn as captured by the
+// kernel when the connection was established.
+func PeerCred(conn *net.UnixConn) (*unix.Ucred, error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ucred *unix.Ucred
+		cErr  error
+	)
+	err = rc.Control(func(fd uintptr) {
+		for {
+			ucred, cErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+			if cErr != unix.EINTR {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cErr != nil {
+		return nil, fmt.Errorf("SO_PEERCRED: %w", cErr)
+	}
+	return ucred, nil
+}
+
+func (a *Authorizer) allowed(id *Identity) bool {
+	for _, uid := range a.UIDs {
+		if uid == id.Uid {
+			return true
+		}
+	}
+
+	for _, name := range a.Users {
+		u, err := a.lookupUsername(name)
+		if err != nil {
+			a.logger().Printf("looking up user %q: %v", name, err)
+			continue
+		}
+		if u.Uid == strconv.Itoa(int(id.Uid)) {
+			return true
+		}
+	}
+
+	for _, group := range a.Groups {
+		gid, err := a.lookupGID(group)
+		if err != nil {
+			a.logger().Printf("looking up group %q: %v", group, err)
+			continue
+		}
+		for _, g := range id.GroupIDs {
+			if g == gid {
+				return true
+			}
+		}
+	}
+
+	if id.Exe != "" {
+		for _, exe := range a.Executables {
+			if exe == id.Exe {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (a *Authorizer) ttl() time.Duration {
+	if a.CacheTTL > 0 {
+		return a.CacheTTL
+	}
+	return DefaultCacheTTL
+}
+
+func (a *Authorizer) logger() *log.Logger {
+	if a.Logger == nil {
+		return log.New(ioutil.Discard, "", 0)
+	}
+	return a.Logger
+}
+
+func (a *Authorizer) lookupUser(uid uint32) (*user.User, error) {
+	v, err := a.cache.lookup("uid:"+strconv.Itoa(int(uid)), a.ttl(),
+		func() (interface{}, error) {
+			return user.LookupId(strconv.Itoa(int(uid)))
+		})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*user.User), nil
+}
+
+func (a *Authorizer) lookupUsername(name string) (*user.User, error) {
+	v, err := a.cache.lookup("user:"+name, a.ttl(),
+		func() (interface{}, error) { return user.Lookup(name) })
+	if err != nil {
+		return nil, err
+	}
+	return v.(*user.User), nil
+}
+
+func (a *Authorizer) groupIDs(u *user.User) ([]string, error) {
+	v, err := a.cache.lookup("groups:"+u.Uid, a.ttl(),
+		func() (interface{}, error) {
+			gids, err := u.GroupIds()
+			if err != nil {
+				return nil, err
+			}
+			for _, gid := range gids {
+				if gid == u.Gid {
+					return gids, nil
+				}
+			}
+			return append(gids, u.Gid), nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]string), nil
+}
+
+// lookupGID returns the group ID of group, which is either a group name or a
+// numeric group ID.
+func (a *Authorizer) lookupGID(group string) (string, error) {
+	if _, err := strconv.Atoi(group); err == nil {
+		return group, nil
+	}
+	v, err := a.cache.lookup("group:"+group, a.ttl(),
+		func() (interface{}, error) {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return nil, err
+			}
+			return g.Gid, nil
+		})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-network/chapter07"
+)
+
+func connPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	l, err := net.ListenUnix("unix",
+		&net.UnixAddr{Name: filepath.Join(dir, "auth.sock"), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.DialUnix("unix", nil, l.Addr().(*net.UnixAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := l.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return server, client
+}
+
+func TestAuthorizer(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, gid := uint32(os.Getuid()), strconv.Itoa(os.Getgid())
+
+	testCases := []struct {
+		name    string
+		a       *Authorizer
+		allowed bool
+	}{
+		{"no policy", &Authorizer{}, false},
+		{"uid", &Authorizer{UIDs: []uint32{uid}}, true},
+		{"other uid", &Authorizer{UIDs: []uint32{uid + 1}}, false},
+		{"gid", &Authorizer{Groups: []string{gid}}, true},
+		{"unknown group", &Authorizer{Groups: []string{"no-such-group-here"}}, false},
+		{"executable", &Authorizer{Executables: []string{exe}}, true},
+		{"other executable", &Authorizer{Executables: []string{"/bin/false"}}, false},
+	}
+
+	for _, c := range testCases {
+		server, _ := connPair(t)
+		id, err := c.a.Authorize(server)
+		if c.allowed && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !c.allowed && !errors.Is(err, ErrDenied) {
+			t.Errorf("%s: expected %v; actual %v", c.name, ErrDenied, err)
+		}
+		if id == nil {
+			t.Fatalf("%s: expected identity", c.name)
+		}
+		if id.Pid != int32(os.Getpid()) || id.Uid != uid {
+			t.Errorf("%s: unexpected credentials %+v", c.name, id.Ucred)
+		}
+	}
+}
+
+func TestAuthorizerUsername(t *testing.T) {
+	server, _ := connPair(t)
+	a := new(Authorizer)
+	id, err := a.Identify(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.User == nil {
+		t.Skip("current user isn't in the user database")
+	}
+	a.Users = []string{id.User.Username}
+	if _, err = a.Authorize(server); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	now := time.Now()
+	c := &cache{now: func() time.Time { return now }}
+	calls := 0
+	fn := func() (interface{}, error) {
+		calls++
+		return calls, errors.New("not found")
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.lookup("key", time.Minute, fn); err == nil {
+			t.Fatal("expected cached error")
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 lookup; actual %d", calls)
+	}
+
+	now = now.Add(time.Minute)
+	_, _ = c.lookup("key", time.Minute, fn)
+	if calls != 2 {
+		t.Fatalf("expected expired entry to be looked up again; actual %d lookups", calls)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ids := make(chan *Identity, 1)
+	allowed := &Authorizer{UIDs: []uint32{uint32(os.Getuid())}}
+	denied := new(Authorizer)
+
+	for _, a := range []*Authorizer{allowed, denied} {
+		s := &chapter07.UnixServer{
+			Addr: filepath.Join(dir, "handler.sock"),
+			Handler: a.Handler(chapter07.HandlerFunc(
+				func(ctx context.Context, conn net.Conn) {
+					id, _ := FromContext(ctx)
+					ids <- id
+					_, _ = conn.Write([]byte("Welcome\n"))
+				})),
+		}
+		if err = s.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		go func() { _ = s.Serve() }()
+
+		conn, err := net.Dial("unix", s.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		_ = conn.Close()
+		_ = s.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a == denied {
+			if line != "Access denied\n" {
+				t.Errorf("expected denial; actual %q", line)
+			}
+			continue
+		}
+		if line != "Welcome\n" {
+			t.Errorf("expected welcome; actual %q", line)
+		}
+		if id := <-ids; id == nil || id.Pid != int32(os.Getpid()) {
+			t.Errorf("unexpected identity in context: %+v", id)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is how long user and group lookups are reused.
+const DefaultCacheTTL = time.Minute
+
+type cacheEntry struct {
+	value   interface{}
+	err     error
+	expires time.Time
+}
+
+// cache memoizes lookups against the user database, which may involve NSS
+// modules doing network round trips. Failed lookups are cached too, so an
+// unknown user can't make every connection pay for a slow lookup.
+type cache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	now     func() time.Time
+}
+
+func (c *cache) lookup(key string, ttl time.Duration, fn func() (interface{}, error)) (interface{}, error) {
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now().Before(e.expires) {
+		return e.value, e.err
+	}
+
+	v, err := fn()
+
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]cacheEntry)
+	}
+	c.entries[key] = cacheEntry{value: v, err: err, expires: now().Add(ttl)}
+	c.mu.Unlock()
+
+	return v, err
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"net"
+
+	"go-network/chapter07"
+)
+
+type identityKey struct{}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity Handler attached to ctx.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// Handler authorizes each connection before handing it to next along with a
+// context carrying the peer's identity. Denied peers get a short message and
+// are disconnected.
+func (a *Authorizer) Handler(next chapter07.Handler) chapter07.Handler {
+	return chapter07.HandlerFunc(func(ctx context.Context, conn net.Conn) {
+		uc, ok := conn.(*net.UnixConn)
+		if !ok {
+			a.logger().Printf("%T isn't a Unix domain socket connection", conn)
+			return
+		}
+
+		id, err := a.Authorize(uc)
+		if err != nil {
+			a.logger().Print(err)
+			_, _ = conn.Write([]byte("Access denied\n"))
+			return
+		}
+
+		next.ServeConn(NewContext(ctx, id), conn)
+	})
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+var ErrNoCredentials = errors.New("message arrived without credentials")
+
+// CredPacketConn reads datagrams from unixgram sockets, or messages from
+// unixpacket connections, along with the credentials of the sending process.
+// SO_PEERCRED only describes the peer of a connected socket; a unixgram
+// socket hears from many senders, so the kernel attaches each sender's
+// credentials to its datagrams as SCM_CREDENTIALS ancillary data instead.
+type CredPacketConn struct {
+	*net.UnixConn
+	oob []byte
+}
+
+// NewCredPacketConn enables SO_PASSCRED on conn. Messages already queued
+// before then may arrive without credentials.
+func NewCredPacketConn(conn *net.UnixConn) (*CredPacketConn, error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var sErr error
+	err = rc.Control(func(fd uintptr) {
+		sErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_PASSCRED, 1)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sErr != nil {
+		return nil, fmt.Errorf("SO_PASSCRED: %w", sErr)
+	}
+	return &CredPacketConn{UnixConn: conn}, nil
+}
+
+// ReadFromCred reads a message into b and returns the number of bytes read,
+// the sender's address, which is nil for unbound senders, and the sender's
+// pid, uid and gid as verified by the kernel. It isn't safe for concurrent
+// use.
+func (c *CredPacketConn) ReadFromCred(b []byte) (int, net.Addr, *unix.Ucred, error) {
+	if c.oob == nil {
+		c.oob = make([]byte, unix.CmsgSpace(unix.SizeofUcred))
+	}
+	n, oobn, _, addr, err := c.ReadMsgUnix(b, c.oob)
+	if err != nil {
+		return n, nil, nil, err
+	}
+	// avoid returning a typed nil inside the net.Addr interface
+	var from net.Addr
+	if addr != nil {
+		from = addr
+	}
+
+	msgs, err := unix.ParseSocketControlMessage(c.oob[:oobn])
+	if err != nil {
+		return n, from, nil, err
+	}
+	for _, m := range msgs {
+		if m.Header.Level != unix.SOL_SOCKET || m.Header.Type != unix.SCM_CREDENTIALS {
+			continue
+		}
+		ucred, err := unix.ParseUnixCredentials(&m)
+		if err != nil {
+			return n, from, nil, err
+		}
+		return n, from, ucred, nil
+	}
+	return n, from, nil, ErrNoCredentials
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredPacketConn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credconn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, network := range []string{"unixgram", "unixpacket"} {
+		sAddr := &net.UnixAddr{Name: filepath.Join(dir, network+".sock"), Net: network}
+
+		var server *net.UnixConn
+		accepted := make(chan *net.UnixConn, 1)
+		if network == "unixgram" {
+			if server, err = net.ListenUnixgram(network, sAddr); err != nil {
+				t.Fatal(err)
+			}
+			accepted <- server
+		} else {
+			l, err := net.ListenUnix(network, sAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			go func() {
+				c, err := l.AcceptUnix()
+				if err != nil {
+					close(accepted)
+					return
+				}
+				accepted <- c
+			}()
+		}
+
+		client, err := net.DialUnix(network, nil, sAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		if server = <-accepted; server == nil {
+			t.Fatal("accept failed")
+		}
+		defer server.Close()
+
+		conn, err := NewCredPacketConn(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 16)
+		n, _, ucred, err := conn.ReadFromCred(buf)
+		if err != nil {
+			t.Fatalf("%s: %v", network, err)
+		}
+		if string(buf[:n]) != "ping" {
+			t.Errorf("%s: expected %q; actual %q", network, "ping", buf[:n])
+		}
+		if ucred.Pid != int32(os.Getpid()) || ucred.Uid != uint32(os.Getuid()) ||
+			ucred.Gid != uint32(os.Getgid()) {
+			t.Errorf("%s: unexpected credentials %+v", network, ucred)
+		}
+
+		a := &Authorizer{UIDs: []uint32{ucred.Uid}}
+		if _, err = a.AuthorizeCred(ucred); err != nil {
+			t.Errorf("%s: %v", network, err)
+		}
+	}
+}
@@ -7,52 +7,28 @@ import (
 )
 
 func streamEchoServer(ctx context.Context, network string, addr string) (net.Addr, error) {
-	s, err := net.Listen(network, addr)
-	if err != nil {
+	s := &UnixServer{Network: network, Addr: addr, Handler: EchoHandler()}
+	if err := s.Listen(); err != nil {
 		return nil, err
 	}
 
 	go func() {
-		go func() {
-			// ctx canceled
-			<-ctx.Done()
-			// close server
-			s.Close()
-		}()
-
-		for {
-			conn, err := s.Accept()
-			if err != nil {
-				return
-			}
-
-			go func() {
-
-				// server send fin to client
-				defer conn.Close()
-
-				// read from client until error happen or EOF
-				for {
-					buf := make([]byte, 1<<10)
-					n, err := conn.Read(buf)
-					if err != nil {
-						return
-					}
-					_, err = conn.Write(buf[:n])
-					if err != nil {
-						return
-					}
-				}
-
-			}()
-		}
-
+		// ctx canceled
+		<-ctx.Done()
+		// close server
+		_ = s.Close()
 	}()
-	return s.Addr(), nil
+	go func() { _ = s.Serve() }()
 
+	return s.Address(), nil
 }
 
 func datagramEchoServer(ctx context.Context, network string, addr string) (net.Addr, error) {
+	if network == "unixgram" && !isAbstract(addr) {
+		if err := removeStaleSocket(network, addr); err != nil {
+			return nil, err
+		}
+	}
 	s, err := net.ListenPacket(network, addr)
 	if err != nil {
 		return nil, err
@@ -62,7 +38,7 @@ func datagramEchoServer(ctx context.Context, network string, addr string) (net.A
 		go func() {
 			<-ctx.Done()
 			s.Close()
-			if network == "unixgram" {
+			if network == "unixgram" && !isAbstract(addr) {
 				os.Remove(addr)
 			}
 		}()
@@ -0,0 +1,110 @@
+//go:build darwin || linux
+// +build darwin linux
+
+// Package fdpass sends open files between processes over Unix domain sockets
+// as SCM_RIGHTS ancillary data. The receiving process gets its own
+// descriptors referring to the same open files, sockets included.
+package fdpass
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	ErrEmptyMessage  = errors.New("files must accompany at least one byte of data")
+	ErrTruncated     = errors.New("control message truncated; too many files sent")
+	ErrUnexpectedFds = errors.New("received more files than expected")
+)
+
+// Send writes msg to conn along with the descriptors of files. Stream
+// sockets attach ancillary data to the bytes that carry it, so msg can't be
+// empty. The caller still owns files and may close them once Send returns.
+func Send(conn *net.UnixConn, msg []byte, files ...*os.File) error {
+	if len(msg) == 0 {
+		return ErrEmptyMessage
+	}
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+
+	var oob []byte
+	if len(fds) > 0 {
+		oob = unix.UnixRights(fds...)
+	}
+	n, oobn, err := conn.WriteMsgUnix(msg, oob, nil)
+	if err != nil {
+		return err
+	}
+	if n != len(msg) || oobn != len(oob) {
+		return fmt.Errorf("short write: %d of %d bytes, %d of %d control bytes",
+			n, len(msg), oobn, len(oob))
+	}
+	return nil
+}
+
+// Receive reads a message into buf along with up to maxFiles descriptors.
+// It returns the number of bytes read and the received files, which the
+// caller must close. If the sender passed more than maxFiles descriptors,
+// the ones that arrived are closed and Receive returns ErrTruncated.
+func Receive(conn *net.UnixConn, buf []byte, maxFiles int) (int, []*os.File, error) {
+	oob := make([]byte, unix.CmsgSpace(maxFiles*4))
+	n, oobn, flags, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return n, nil, err
+	}
+
+	fds, err := parseRights(oob[:oobn])
+	if err == nil && flags&unix.MSG_CTRUNC != 0 {
+		err = ErrTruncated
+	}
+	if err == nil && len(fds) > maxFiles {
+		err = ErrUnexpectedFds
+	}
+	if err != nil {
+		for _, fd := range fds {
+			_ = unix.Close(fd)
+		}
+		return n, nil, err
+	}
+
+	files := make([]*os.File, len(fds))
+	for i, fd := range fds {
+		// Linux receives descriptors close-on-exec already; other
+		// platforms need it set here
+		unix.CloseOnExec(fd)
+		files[i] = os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+	}
+	return n, files, nil
+}
+
+func parseRights(oob []byte) ([]int, error) {
+	if len(oob) == 0 {
+		return nil, nil
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+
+	var fds []int
+	for _, m := range msgs {
+		if m.Header.Level != unix.SOL_SOCKET || m.Header.Type != unix.SCM_RIGHTS {
+			continue
+		}
+		rights, err := unix.ParseUnixRights(&m)
+		if err != nil {
+			for _, fd := range fds {
+				_ = unix.Close(fd)
+			}
+			return nil, err
+		}
+		fds = append(fds, rights...)
+	}
+	return fds, nil
+}
@@ -0,0 +1,266 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package fdpass
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"go-network/chapter07"
+)
+
+func socketPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		c, err := net.FileConn(f)
+		_ = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = c.(*net.UnixConn)
+		t.Cleanup(func() { _ = c.Close() })
+	}
+	return conns[0], conns[1]
+}
+
+func tempFile(t *testing.T, content string) *os.File {
+	f, err := ioutil.TempFile("", "fdpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Remove(f.Name()) })
+	if _, err = f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestSendReceive(t *testing.T) {
+	a, b := socketPair(t)
+
+	f1, f2 := tempFile(t, "first"), tempFile(t, "second")
+	if err := Send(a, []byte("files"), f1, f2); err != nil {
+		t.Fatal(err)
+	}
+	// the received descriptors stay valid after the sender closes its own
+	_ = f1.Close()
+	_ = f2.Close()
+
+	buf := make([]byte, 16)
+	n, files, err := Receive(b, buf, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "files" {
+		t.Errorf("expected %q; actual %q", "files", buf[:n])
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files; actual %d", len(files))
+	}
+
+	for i, expected := range []string{"first", "second"} {
+		b, err := ioutil.ReadAll(io.NewSectionReader(files[i], 0, 1<<10))
+		_ = files[i].Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("%d: expected %q; actual %q", i, expected, b)
+		}
+	}
+}
+
+func TestReceiveTooManyFiles(t *testing.T) {
+	a, b := socketPair(t)
+
+	f1, f2 := tempFile(t, "first"), tempFile(t, "second")
+	defer f1.Close()
+	defer f2.Close()
+	if err := Send(a, []byte("files"), f1, f2); err != nil {
+		t.Fatal(err)
+	}
+
+	_, files, err := Receive(b, make([]byte, 16), 1)
+	if err != ErrTruncated && err != ErrUnexpectedFds {
+		t.Fatalf("expected truncation error; actual %v", err)
+	}
+	if files != nil {
+		t.Fatalf("expected no files; actual %d", len(files))
+	}
+}
+
+func TestSendEmptyMessage(t *testing.T) {
+	a, _ := socketPair(t)
+	f := tempFile(t, "")
+	defer f.Close()
+
+	if err := Send(a, nil, f); err != ErrEmptyMessage {
+		t.Fatalf("expected %v; actual %v", ErrEmptyMessage, err)
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	control := filepath.Join(dir, "control.sock")
+
+	old, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := old.Addr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- Handoff(ctx, control, old) }()
+
+	var l net.Listener
+	for {
+		l, err = Takeover(ctx, control)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond) // control socket not up yet
+	}
+	defer l.Close()
+
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	// the old process stops listening; the socket stays open in the new one
+	_ = old.Close()
+
+	if actual := l.Addr().String(); actual != addr {
+		t.Fatalf("expected listener on %s; actual %s", addr, actual)
+	}
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("new"))
+		_ = conn.Close()
+	}()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "new" {
+		t.Fatalf("expected %q; actual %q", "new", b)
+	}
+}
+
+func TestHandoffStaleControlSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	control := filepath.Join(dir, "control.sock")
+
+	// a crashed process leaves its control socket behind
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: control, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	old, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- Handoff(ctx, control, old) }()
+
+	var l net.Listener
+	for {
+		l, err = Takeover(ctx, control)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond) // control socket not up yet
+	}
+	_ = l.Close()
+
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandoffUnixListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "server.sock")
+
+	old, err := chapter07.ListenUnix("unix", socket, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := socketPair(t)
+	if err = SendListener(a, old); err != nil {
+		t.Fatal(err)
+	}
+	l, err := ReceiveListener(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// closing the old listener must leave the socket file in place
+	_ = old.Close()
+	if _, err = os.Stat(socket); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,158 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package fdpass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"go-network/chapter07"
+)
+
+// filer is implemented by *net.TCPListener, *net.UnixListener and the
+// listeners chapter07.ListenUnix returns.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// unlinker is implemented by the Unix listeners filer covers.
+type unlinker interface {
+	SetUnlinkOnClose(unlink bool)
+}
+
+const ack = "ok"
+
+// SendListener passes the listening socket of l over conn. The receiving
+// process accepts connections on the same socket, so none are refused while
+// the sender winds down. The sender should stop calling Accept and close l
+// once the receiver acknowledges the handoff.
+func SendListener(conn *net.UnixConn, l net.Listener) error {
+	fl, ok := l.(filer)
+	if !ok {
+		return fmt.Errorf("%T doesn't expose its file descriptor", l)
+	}
+	f, err := fl.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if ul, ok := l.(unlinker); ok {
+		// the socket file now belongs to the receiver as well
+		ul.SetUnlinkOnClose(false)
+	}
+
+	addr := l.Addr()
+	return Send(conn, []byte(addr.Network()+" "+addr.String()), f)
+}
+
+// ReceiveListener receives a listening socket sent by SendListener.
+func ReceiveListener(conn *net.UnixConn) (net.Listener, error) {
+	buf := make([]byte, 1<<10)
+	n, files, err := Receive(conn, buf, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) != 1 {
+		return nil, fmt.Errorf("expected 1 file; received %d", len(files))
+	}
+	f := files[0]
+	defer f.Close()
+
+	// net.FileListener doesn't need the description, but its absence means
+	// the descriptor didn't come from SendListener
+	if fields := strings.SplitN(string(buf[:n]), " ", 2); len(fields) != 2 {
+		return nil, fmt.Errorf("malformed listener description %q", buf[:n])
+	}
+	return net.FileListener(f)
+}
+
+// Handoff waits on the control socket at path for a new process to call
+// Takeover, sends it l's listening socket and waits for its acknowledgment.
+// Once Handoff returns nil, the caller should stop accepting connections,
+// close l and finish serving the connections it already has.
+func Handoff(ctx context.Context, path string, l net.Listener) error {
+	// replaces a control socket a crashed process left behind
+	control, err := chapter07.ListenUnix("unix", path, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer control.Close()
+
+	go func() {
+		<-ctx.Done()
+		_ = control.Close()
+	}()
+
+	for {
+		c, err := control.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		conn := c.(*net.UnixConn)
+		err = handoff(ctx, conn, l)
+		_ = conn.Close()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// a failed or impatient successor; wait for the next one
+	}
+}
+
+func handoff(ctx context.Context, conn *net.UnixConn, l net.Listener) error {
+	if err := SendListener(conn, l); err != nil {
+		return err
+	}
+	if d, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(d)
+	}
+	buf := make([]byte, len(ack))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	if string(buf[:n]) != ack {
+		return errors.New("handoff not acknowledged")
+	}
+	return nil
+}
+
+// Takeover connects to the control socket at path, receives the listening
+// socket of the process calling Handoff and acknowledges it.
+func Takeover(ctx context.Context, path string) (net.Listener, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	conn := c.(*net.UnixConn)
+	defer conn.Close()
+
+	deadline := time.Now().Add(30 * time.Second)
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	l, err := ReceiveListener(conn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write([]byte(ack)); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	return l, nil
+}
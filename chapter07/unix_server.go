@@ -0,0 +1,364 @@
+package chapter07
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrServerClosed = errors.New("unix server closed")
+
+// Handler serves a single accepted connection. The context is canceled when
+// the server starts shutting down; the server closes conn once ServeConn
+// returns.
+type Handler interface {
+	ServeConn(ctx context.Context, conn net.Conn)
+}
+
+type HandlerFunc func(ctx context.Context, conn net.Conn)
+
+func (f HandlerFunc) ServeConn(ctx context.Context, conn net.Conn) { f(ctx, conn) }
+
+// EchoHandler writes everything it reads back to the client.
+func EchoHandler() Handler {
+	return HandlerFunc(func(_ context.Context, conn net.Conn) {
+		buf := make([]byte, 1<<10)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if _, err = conn.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// Owner is the user and group a socket file is chowned to.
+type Owner struct {
+	UID, GID int
+}
+
+// UnixServer serves stream ("unix") or sequenced packet ("unixpacket")
+// sockets. Addresses beginning with "@" live in the Linux abstract namespace
+// and have no socket file; for filesystem addresses the server replaces a
+// stale socket file left behind by a crashed process, but refuses to touch a
+// socket that still accepts connections.
+type UnixServer struct {
+	Network string      // "unix" or "unixpacket"; defaults to "unix"
+	Addr    string      // socket file path, or "@name" for the abstract namespace
+	Mode    os.FileMode // socket file permissions; zero keeps the umask default
+	Owner   *Owner      // socket file owner; nil keeps the process's user and group
+	Handler Handler
+	Logger  *log.Logger // defaults to discarding log output
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// ListenAndServe binds the socket and serves connections until Shutdown or
+// Close is called, at which point it returns ErrServerClosed.
+func (s *UnixServer) ListenAndServe() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Listen binds the socket so callers can connect before Serve is running.
+func (s *UnixServer) Listen() error {
+	if s.Network == "" {
+		s.Network = "unix"
+	}
+	if s.Network != "unix" && s.Network != "unixpacket" {
+		return fmt.Errorf("unsupported network %q", s.Network)
+	}
+	if s.Addr == "" {
+		return errors.New("address is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	if s.listener != nil {
+		return errors.New("already listening")
+	}
+
+	l, err := s.listen()
+	if err != nil {
+		return err
+	}
+	s.listener = l
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.conns = make(map[net.Conn]struct{})
+	return nil
+}
+
+func (s *UnixServer) listen() (net.Listener, error) {
+	return ListenUnix(s.Network, s.Addr, s.Mode, s.Owner)
+}
+
+// ListenUnix binds a "unix" or "unixpacket" listener to addr, either a socket
+// file path or "@name" for the abstract namespace. It replaces a stale socket
+// file but refuses to touch one that still accepts connections. A non-zero
+// mode and a non-nil owner are applied to the socket file before clients can
+// see it. Closing the listener removes the socket file. The listener has File
+// and SetUnlinkOnClose methods like *net.UnixListener's.
+func ListenUnix(network, addr string, mode os.FileMode, owner *Owner) (net.Listener, error) {
+	if isAbstract(addr) {
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("binding to %s %s: %w", network, addr, err)
+		}
+		return l, nil
+	}
+
+	if err := removeStaleSocket(network, addr); err != nil {
+		return nil, err
+	}
+
+	// Bind a temporary name, set its mode and owner, then move it into
+	// place, so clients never see the socket with the wrong permissions.
+	tmp := filepath.Join(filepath.Dir(addr),
+		fmt.Sprintf(".%s.%d.tmp", filepath.Base(addr), os.Getpid()))
+	_ = os.Remove(tmp)
+	l, err := net.Listen(network, tmp)
+	if err != nil {
+		return nil, fmt.Errorf("binding to %s %s: %w", network, addr, err)
+	}
+	// the socket file ends up at addr, which unixListener removes itself
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	err = setPermissions(tmp, mode, owner)
+	if err == nil {
+		err = os.Rename(tmp, addr)
+	}
+	if err != nil {
+		_ = l.Close()
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	return &unixListener{Listener: l, addr: &net.UnixAddr{Name: addr, Net: network}}, nil
+}
+
+// unixListener reports the path its socket file was moved to and removes the
+// file on Close unless SetUnlinkOnClose(false) was called.
+type unixListener struct {
+	net.Listener
+	addr *net.UnixAddr
+	keep bool // the socket file outlives the listener
+}
+
+func (l *unixListener) Addr() net.Addr { return l.addr }
+
+// File returns a copy of the listening socket's file descriptor, as
+// (*net.UnixListener).File does, so the socket can be passed to another
+// process.
+func (l *unixListener) File() (*os.File, error) {
+	return l.Listener.(*net.UnixListener).File()
+}
+
+// SetUnlinkOnClose sets whether Close removes the socket file, as
+// (*net.UnixListener).SetUnlinkOnClose does. Turn it off once another process
+// serves on the socket.
+func (l *unixListener) SetUnlinkOnClose(unlink bool) {
+	l.keep = !unlink
+}
+
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	if l.keep {
+		return err
+	}
+	if rErr := os.Remove(l.addr.Name); rErr != nil && !os.IsNotExist(rErr) && err == nil {
+		err = rErr
+	}
+	return err
+}
+
+func setPermissions(path string, mode os.FileMode, owner *Owner) error {
+	if mode != 0 {
+		if err := os.Chmod(path, os.ModeSocket|mode.Perm()); err != nil {
+			return err
+		}
+	}
+	if owner != nil {
+		if err := os.Chown(path, owner.UID, owner.GID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Serve accepts connections on the socket bound by Listen and hands each to
+// the Handler in its own goroutine.
+func (s *UnixServer) Serve() error {
+	s.mu.Lock()
+	l, ctx, handler := s.listener, s.ctx, s.Handler
+	if s.Logger == nil {
+		s.Logger = log.New(ioutil.Discard, "", 0)
+	}
+	s.mu.Unlock()
+
+	if l == nil {
+		return errors.New("Serve called before Listen")
+	}
+	if handler == nil {
+		handler = EchoHandler()
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ErrServerClosed
+			}
+			if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+				// out of file descriptors; give handlers a chance to finish
+				s.Logger.Printf("accept: %v", err)
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+
+		if !s.track(conn) {
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrack(conn)
+			handler.ServeConn(ctx, conn)
+		}()
+	}
+}
+
+func (s *UnixServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *UnixServer) untrack(conn net.Conn) {
+	_ = conn.Close()
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// Address returns the address the server listens on, or nil before Listen.
+func (s *UnixServer) Address() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Shutdown stops accepting connections, removes the socket file and cancels
+// the context passed to handlers. It then waits for the handlers to return.
+// If ctx ends first, Shutdown closes the remaining connections and returns
+// ctx's error.
+func (s *UnixServer) Shutdown(ctx context.Context) error {
+	err := s.stop()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		s.closeConns()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Close stops the server and closes all connections immediately.
+func (s *UnixServer) Close() error {
+	err := s.stop()
+	s.closeConns()
+	s.wg.Wait()
+	return err
+}
+
+func (s *UnixServer) stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.listener == nil {
+		return nil
+	}
+	s.cancel()
+	return s.listener.Close()
+}
+
+func (s *UnixServer) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+func isAbstract(addr string) bool {
+	return strings.HasPrefix(addr, "@")
+}
+
+// removeStaleSocket removes the socket file at path if no process accepts
+// connections on it anymore. It refuses to remove files that aren't sockets
+// and sockets that are still in use.
+func removeStaleSocket(network, path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout(network, path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s: %w", path, syscall.EADDRINUSE)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("checking %s: %w", path, err)
+	}
+	// nobody is listening
+	return os.Remove(path)
+}
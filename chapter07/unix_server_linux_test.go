@@ -0,0 +1,21 @@
+package chapter07
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestUnixServerAbstract(t *testing.T) {
+	addr := fmt.Sprintf("@go-network-%d", os.Getpid())
+	s := &UnixServer{Addr: addr}
+	done := serve(t, s)
+	ping(t, "unix", addr)
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != ErrServerClosed {
+		t.Fatalf("expected %v; actual %v", ErrServerClosed, err)
+	}
+}
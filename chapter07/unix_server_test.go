@@ -0,0 +1,198 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package chapter07
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func tempSocket(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "unix_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return filepath.Join(dir, "server.sock")
+}
+
+func ping(t *testing.T, network, addr string) {
+	t.Helper()
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := string(buf[:n]); actual != "ping" {
+		t.Fatalf("expected %q; actual %q", "ping", actual)
+	}
+}
+
+func serve(t *testing.T, s *UnixServer) <-chan error {
+	t.Helper()
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve() }()
+	return done
+}
+
+func TestUnixServerStaleSocket(t *testing.T) {
+	socket := tempSocket(t)
+
+	// leave a socket file behind, as a crashed server would
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	s := &UnixServer{Addr: socket, Mode: 0600}
+	done := serve(t, s)
+	ping(t, "unix", socket)
+
+	fi, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("expected mode %o; actual %o", 0600, perm)
+	}
+
+	// a second server must not steal the live socket
+	err = (&UnixServer{Addr: socket}).Listen()
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("expected %v; actual %v", syscall.EADDRINUSE, err)
+	}
+	ping(t, "unix", socket)
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != ErrServerClosed {
+		t.Fatalf("expected %v; actual %v", ErrServerClosed, err)
+	}
+	if _, err = os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("expected socket file to be removed; actual %v", err)
+	}
+}
+
+func TestUnixServerRefusesRegularFile(t *testing.T) {
+	socket := tempSocket(t)
+	if err := ioutil.WriteFile(socket, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&UnixServer{Addr: socket}).Listen(); err == nil {
+		t.Fatal("expected error")
+	}
+	if b, err := ioutil.ReadFile(socket); err != nil || string(b) != "data" {
+		t.Fatalf("file was modified: %q %v", b, err)
+	}
+}
+
+func TestUnixServerOwner(t *testing.T) {
+	socket := tempSocket(t)
+	s := &UnixServer{Network: "unixpacket", Addr: socket,
+		Owner: &Owner{UID: os.Getuid(), GID: os.Getgid()}}
+	serve(t, s)
+	defer s.Close()
+
+	fi, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if int(st.Uid) != os.Getuid() || int(st.Gid) != os.Getgid() {
+			t.Errorf("expected owner %d:%d; actual %d:%d",
+				os.Getuid(), os.Getgid(), st.Uid, st.Gid)
+		}
+	}
+	ping(t, "unixpacket", socket)
+}
+
+func TestUnixServerShutdown(t *testing.T) {
+	socket := tempSocket(t)
+
+	started := make(chan struct{})
+	s := &UnixServer{
+		Addr: socket,
+		Handler: HandlerFunc(func(ctx context.Context, conn net.Conn) {
+			close(started)
+			// a well-behaved handler finishes up once the server shuts down
+			<-ctx.Done()
+			_, _ = conn.Write([]byte("bye"))
+		}),
+	}
+	done := serve(t, s)
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != ErrServerClosed {
+		t.Fatalf("expected %v; actual %v", ErrServerClosed, err)
+	}
+
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "bye" {
+		t.Fatalf("expected %q; actual %q", "bye", b)
+	}
+}
+
+func TestUnixServerShutdownDeadline(t *testing.T) {
+	socket := tempSocket(t)
+
+	started := make(chan struct{})
+	s := &UnixServer{
+		Addr: socket,
+		Handler: HandlerFunc(func(_ context.Context, conn net.Conn) {
+			close(started)
+			// ignores shutdown and blocks until its connection is closed
+			_, _ = conn.Read(make([]byte, 1))
+		}),
+	}
+	serve(t, s)
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v; actual %v", context.DeadlineExceeded, err)
+	}
+}
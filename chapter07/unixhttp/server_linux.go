@@ -0,0 +1,51 @@
+package unixhttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"go-network/chapter07/creds/auth"
+)
+
+// NewServer returns a server whose handlers find the identity of the peer
+// process in the request context; see auth.FromContext. The identity is
+// looked up once per connection. If a is non-nil, requests from peers a
+// denies get 403 Forbidden without reaching h. Serve it on a listener from
+// chapter07.ListenUnix.
+func NewServer(h http.Handler, a *auth.Authorizer) *http.Server {
+	return &http.Server{
+		Handler:     authorize(h),
+		ConnContext: connContext(a),
+	}
+}
+
+func connContext(a *auth.Authorizer) func(context.Context, net.Conn) context.Context {
+	// without policies, identify peers but let them all in
+	identify := new(auth.Authorizer).Identify
+	if a != nil {
+		identify = a.Authorize
+	}
+
+	return func(ctx context.Context, c net.Conn) context.Context {
+		uc, ok := c.(*net.UnixConn)
+		if !ok {
+			return ctx
+		}
+		id, err := identify(uc)
+		if err != nil {
+			return ctx
+		}
+		return auth.NewContext(ctx, id)
+	}
+}
+
+func authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.FromContext(r.Context()); !ok {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
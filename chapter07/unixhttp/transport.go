@@ -0,0 +1,70 @@
+// Package unixhttp speaks HTTP over Unix domain sockets, the way local
+// daemons such as Docker expose their admin APIs.
+package unixhttp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Scheme is the URL scheme of requests sent over a Unix domain socket.
+const Scheme = "http+unix"
+
+// NewTransport returns a transport for http and https URLs that also
+// handles http+unix URLs. Go's URL parser doesn't allow a socket path in the
+// host, so the host of an http+unix URL is a name looked up in sockets, which
+// maps names to socket paths. With sockets["docker"] = "/var/run/docker.sock",
+// a GET of http+unix://docker/v1.40/info asks the daemon for /v1.40/info.
+func NewTransport(sockets map[string]string) *http.Transport {
+	paths := make(map[string]string, len(sockets))
+	for name, path := range sockets {
+		paths[name] = path
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.RegisterProtocol(Scheme, &roundTripper{
+		Transport: &http.Transport{
+			DialContext:           dialer(paths),
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	})
+	return t
+}
+
+func dialer(paths map[string]string) func(context.Context, string, string) (net.Conn, error) {
+	var d net.Dialer
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		path, ok := paths[host]
+		if !ok {
+			return nil, fmt.Errorf("no socket for host %q", host)
+		}
+		return d.DialContext(ctx, "unix", path)
+	}
+}
+
+// roundTripper sends http+unix requests as plain HTTP over connections its
+// Transport dials to the socket the host names.
+type roundTripper struct {
+	*http.Transport
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.URL.Scheme = "http"
+
+	resp, err := rt.Transport.RoundTrip(r)
+	if resp != nil {
+		// redirects resolve against the http+unix URL
+		resp.Request = req
+	}
+	return resp, err
+}
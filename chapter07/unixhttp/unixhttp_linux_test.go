@@ -0,0 +1,73 @@
+package unixhttp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-network/chapter07"
+	"go-network/chapter07/creds/auth"
+)
+
+func serve(t *testing.T, socket string, a *auth.Authorizer) {
+	l, err := chapter07.ListenUnix("unix", socket, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := auth.FromContext(r.Context())
+		_, _ = fmt.Fprintf(w, "%s %d", r.URL.Path, id.Pid)
+	}), a)
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Close() })
+}
+
+func TestUnixHTTP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unixhttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	open, closed := filepath.Join(dir, "open.sock"), filepath.Join(dir, "closed.sock")
+	serve(t, open, nil)
+	serve(t, closed, new(auth.Authorizer)) // no policies: everyone is denied
+
+	client := &http.Client{Transport: NewTransport(map[string]string{
+		"open":   open,
+		"closed": closed,
+	})}
+
+	testCases := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"http+unix://open/v1/info", http.StatusOK, fmt.Sprintf("/v1/info %d", os.Getpid())},
+		{"http+unix://closed/v1/info", http.StatusForbidden, "Forbidden\n"},
+	}
+	for _, c := range testCases {
+		resp, err := client.Get(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != c.status {
+			t.Errorf("%s: expected status %d; actual %d", c.url, c.status, resp.StatusCode)
+		}
+		if string(b) != c.body {
+			t.Errorf("%s: expected body %q; actual %q", c.url, c.body, b)
+		}
+	}
+
+	if _, err = client.Get("http+unix://unknown/"); err == nil {
+		t.Error("expected error for unknown socket name")
+	}
+}
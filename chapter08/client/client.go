@@ -0,0 +1,347 @@
+// Package client wraps http.Client with the safeguards chapter08's tests
+// demonstrate by their absence: every attempt has a deadline, response
+// bodies are drained so connections get reused, idempotent requests are
+// retried with backoff, and slow requests can be hedged.
+package client
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTimeout       = 30 * time.Second
+	DefaultMaxRetries    = 2
+	DefaultMinBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff    = 5 * time.Second
+	DefaultMaxRetryAfter = 30 * time.Second
+
+	// maxDrain bounds the bytes read from an unread body to reuse its
+	// connection; beyond it, closing the connection is cheaper.
+	maxDrain = 64 << 10
+)
+
+// PoolOptions tune the connection pool of the transport NewTransport builds.
+// Zero values keep http.DefaultTransport's settings.
+type PoolOptions struct {
+	MaxIdleConns          int // across all hosts
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	IdleConnTimeout       time.Duration
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	DisableKeepAlives     bool
+}
+
+// NewTransport returns a copy of http.DefaultTransport with p applied.
+func NewTransport(p PoolOptions) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if p.DialTimeout > 0 || p.KeepAlive > 0 {
+		d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		if p.DialTimeout > 0 {
+			d.Timeout = p.DialTimeout
+		}
+		if p.KeepAlive > 0 {
+			d.KeepAlive = p.KeepAlive
+		}
+		t.DialContext = d.DialContext
+	}
+	if p.MaxIdleConns > 0 {
+		t.MaxIdleConns = p.MaxIdleConns
+	}
+	if p.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = p.MaxIdleConnsPerHost
+	}
+	if p.MaxConnsPerHost > 0 {
+		t.MaxConnsPerHost = p.MaxConnsPerHost
+	}
+	if p.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = p.IdleConnTimeout
+	}
+	if p.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = p.TLSHandshakeTimeout
+	}
+	if p.ResponseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = p.ResponseHeaderTimeout
+	}
+	t.DisableKeepAlives = p.DisableKeepAlives
+	return t
+}
+
+// Client sends requests with timeouts, retries and hedging. The zero value
+// uses http.DefaultTransport and the defaults above.
+type Client struct {
+	Transport http.RoundTripper // defaults to http.DefaultTransport
+
+	// Timeout bounds each attempt, reading the response body included.
+	// Defaults to DefaultTimeout; a deadline on the request's context
+	// bounds all attempts together.
+	Timeout time.Duration
+
+	// MaxRetries is the number of retries after the first attempt of an
+	// idempotent request. Defaults to DefaultMaxRetries; negative disables
+	// retries.
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the randomized exponential delay
+	// between attempts.
+	MinBackoff, MaxBackoff time.Duration
+	// MaxRetryAfter is the longest Retry-After delay honored; responses
+	// asking for more are returned as they are.
+	MaxRetryAfter time.Duration
+
+	// HedgeDelay, if set, sends a second copy of a GET or HEAD request when
+	// the first hasn't answered within the delay, and uses whichever
+	// answers first.
+	HedgeDelay time.Duration
+
+	CheckRedirect func(req *http.Request, via []*http.Request) error
+	Jar           http.CookieJar
+}
+
+// Get issues a GET request to url.
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends req, retrying idempotent requests after network errors and 429,
+// 502, 503 and 504 responses. Requests with a body are only retried if
+// req.GetBody is set, as http.NewRequest does for common body types. A
+// request counts as idempotent if its method is, or if it carries an
+// Idempotency-Key header. Closing the response body drains what's left of
+// it, up to a limit, so the connection returns to the pool.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	retries := c.MaxRetries
+	if retries == 0 {
+		retries = DefaultMaxRetries
+	}
+	if !idempotent(req) || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		resp, err := c.hedge(req)
+		if attempt >= retries {
+			return resp, err
+		}
+
+		var delay time.Duration
+		switch {
+		case err != nil:
+			if req.Context().Err() != nil {
+				return nil, err // the caller gave up
+			}
+			delay = c.backoff(attempt)
+		case retryableStatus(resp.StatusCode):
+			var ok bool
+			if delay, ok = c.retryAfter(resp, attempt); !ok {
+				return resp, nil
+			}
+			_ = resp.Body.Close()
+		default:
+			return resp, nil
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			t.Stop()
+			return nil, req.Context().Err()
+		case <-t.C:
+		}
+	}
+}
+
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns a random delay up to MinBackoff * 2^attempt, capped at
+// MaxBackoff ("full jitter"), so clients that failed together don't retry
+// together.
+func (c *Client) backoff(attempt int) time.Duration {
+	min, max := c.MinBackoff, c.MaxBackoff
+	if min <= 0 {
+		min = DefaultMinBackoff
+	}
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+	d := min << uint(attempt)
+	if d > max || d <= 0 {
+		d = max
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// retryAfter returns the delay the response's Retry-After header asks for,
+// or the backoff if it has none. It returns false if the delay exceeds
+// MaxRetryAfter.
+func (c *Client) retryAfter(resp *http.Response, attempt int) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return c.backoff(attempt), true
+	}
+
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	} else {
+		return c.backoff(attempt), true
+	}
+	if d < 0 {
+		d = 0
+	}
+
+	max := c.MaxRetryAfter
+	if max <= 0 {
+		max = DefaultMaxRetryAfter
+	}
+	return d, d <= max
+}
+
+type result struct {
+	resp *http.Response
+	err  error
+	i    int // which attempt
+}
+
+// hedge sends req and, for hedgeable requests still waiting after
+// HedgeDelay, a copy of it. It returns the first response and cancels the
+// other attempt.
+func (c *Client) hedge(req *http.Request) (*http.Response, error) {
+	hedgeable := c.HedgeDelay > 0 &&
+		(req.Method == http.MethodGet || req.Method == http.MethodHead) &&
+		(req.Body == nil || req.Body == http.NoBody)
+	if !hedgeable {
+		r := c.attempt(req)
+		return r.resp, r.err
+	}
+
+	results := make(chan result, 2)
+	var cancels [2]context.CancelFunc
+	start := func(i int) {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels[i] = cancel
+		go func() {
+			r := c.attempt(req.Clone(ctx))
+			r.i = i
+			results <- r
+		}()
+	}
+
+	start(0)
+	t := time.NewTimer(c.HedgeDelay)
+	defer t.Stop()
+
+	var first result
+	select {
+	case first = <-results:
+	case <-t.C:
+		start(1)
+		first = <-results
+		if first.err != nil {
+			// the other attempt may still succeed
+			cancels[first.i]()
+			first = <-results
+		} else {
+			// the other attempt lost; its response, if any, is discarded
+			cancels[1-first.i]()
+			go func() {
+				if r := <-results; r.resp != nil {
+					_ = r.resp.Body.Close()
+				}
+			}()
+		}
+	}
+
+	if first.resp == nil {
+		cancels[first.i]()
+		return nil, first.err
+	}
+	// the winner's context lives until its body is closed
+	body := first.resp.Body.(*drainingBody)
+	attemptCancel, hedgeCancel := body.cancel, cancels[first.i]
+	body.cancel = func() {
+		attemptCancel()
+		hedgeCancel()
+	}
+	return first.resp, nil
+}
+
+// attempt sends req once with its own timeout. The timeout stays in effect
+// until the response body is closed.
+func (c *Client) attempt(req *http.Request) result {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+
+	hc := &http.Client{
+		Transport:     c.Transport,
+		CheckRedirect: c.CheckRedirect,
+		Jar:           c.Jar,
+	}
+	resp, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return result{err: err}
+	}
+	resp.Body = &drainingBody{ReadCloser: resp.Body, cancel: cancel}
+	return result{resp: resp}
+}
+
+// drainingBody reads what's left of a body on Close, so the transport can
+// reuse the connection, and releases the attempt's timeout.
+type drainingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (b *drainingBody) Close() error {
+	var err error
+	b.once.Do(func() {
+		_, _ = io.CopyN(io.Discard, b.ReadCloser, maxDrain)
+		err = b.ReadCloser.Close()
+		b.cancel()
+	})
+	return err
+}
@@ -0,0 +1,208 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := &Client{Timeout: 50 * time.Millisecond, MaxRetries: -1}
+	start := time.Now()
+	_, err := c.Get(context.Background(), srv.URL)
+	if err == nil {
+		t.Fatal("expected a timeout instead of blocking indefinitely")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timeout took %v", elapsed)
+	}
+}
+
+func TestTimeoutCoversBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c := &Client{Timeout: 50 * time.Millisecond}
+	resp, err := c.Get(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err = ioutil.ReadAll(resp.Body); err == nil {
+		t.Fatal("expected the body read to time out")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			_, _ = w.Write(b)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+	testCases := []struct {
+		method string
+		header string
+		calls  int32
+		code   int
+	}{
+		{http.MethodPut, "", 3, http.StatusOK},
+		{http.MethodPost, "", 1, http.StatusServiceUnavailable},
+		{http.MethodPost, "Idempotency-Key", 3, http.StatusOK},
+	}
+	for _, tc := range testCases {
+		atomic.StoreInt32(&calls, 0)
+		req, err := http.NewRequest(tc.method, srv.URL, strings.NewReader("payload"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.header != "" {
+			req.Header.Set(tc.header, "key-1")
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+
+		if n := atomic.LoadInt32(&calls); n != tc.calls {
+			t.Errorf("%s %s: expected %d calls; actual %d", tc.method, tc.header, tc.calls, n)
+		}
+		if resp.StatusCode != tc.code {
+			t.Errorf("%s %s: expected status %d; actual %d", tc.method, tc.header, tc.code, resp.StatusCode)
+		}
+		if tc.code == http.StatusOK && string(b) != "payload" {
+			t.Errorf("%s %s: body wasn't resent: %q", tc.method, tc.header, b)
+		}
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	resp, err := new(Client).Get(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || calls != 1 {
+		t.Errorf("expected the 503 without retrying; actual %d after %d calls", resp.StatusCode, calls)
+	}
+}
+
+func TestHedging(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// the first attempt hangs until it's canceled
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte("hedged"))
+	}))
+	defer srv.Close()
+
+	c := &Client{HedgeDelay: 20 * time.Millisecond, Timeout: 5 * time.Second}
+	start := time.Now()
+	resp, err := c.Get(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if string(b) != "hedged" {
+		t.Errorf("expected the hedged response; actual %q", b)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hedge didn't cut the wait short: %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("expected 2 calls; actual %d", n)
+	}
+}
+
+func TestDrainReusesConnections(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bytes.Repeat([]byte("x"), 4096))
+	}))
+	defer srv.Close()
+
+	c := &Client{Transport: NewTransport(PoolOptions{MaxIdleConnsPerHost: 1})}
+	var reused int
+	for i := 0; i < 3; i++ {
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if info.Reused {
+					reused++
+				}
+			},
+		}
+		ctx := httptrace.WithClientTrace(context.Background(), trace)
+		resp, err := c.Get(ctx, srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// close without reading: the client drains the body
+		_ = resp.Body.Close()
+	}
+	if reused != 2 {
+		t.Errorf("expected 2 reused connections; actual %d", reused)
+	}
+}
+
+func TestNewTransport(t *testing.T) {
+	tr := NewTransport(PoolOptions{
+		MaxIdleConns:        7,
+		MaxIdleConnsPerHost: 3,
+		MaxConnsPerHost:     5,
+		IdleConnTimeout:     time.Second,
+		DisableKeepAlives:   true,
+	})
+	if tr.MaxIdleConns != 7 || tr.MaxIdleConnsPerHost != 3 || tr.MaxConnsPerHost != 5 ||
+		tr.IdleConnTimeout != time.Second || !tr.DisableKeepAlives {
+		t.Errorf("options not applied: %+v", tr)
+	}
+	if def := http.DefaultTransport.(*http.Transport); def.MaxIdleConns == 7 {
+		t.Error("NewTransport modified http.DefaultTransport")
+	}
+}
@@ -0,0 +1,301 @@
+// Package httpcache implements a private or shared HTTP cache (RFC 9111) as
+// an http.RoundTripper. Fresh responses are served from a Store; stale ones
+// are revalidated with If-None-Match and If-Modified-Since.
+package httpcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// XFromCache is set to "1" on responses served from the cache, including
+// those the origin confirmed with 304 Not Modified.
+const XFromCache = "X-From-Cache"
+
+// entry is a stored response together with the request headers it varies on.
+type entry struct {
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	Vary         http.Header // the request's values of the headers named by Vary
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+func (e *entry) date() time.Time {
+	if d, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return d
+	}
+	return e.ResponseTime
+}
+
+// matches reports whether req selects this entry's variant.
+func (e *entry) matches(req *http.Request) bool {
+	for name, values := range e.Vary {
+		if strings.Join(req.Header.Values(name), ", ") != strings.Join(values, ", ") {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *entry) response(req *http.Request) *http.Response {
+	h := e.Header.Clone()
+	h.Set("Age", strconv.FormatInt(int64(currentAge(e)/time.Second), 10))
+	h.Set(XFromCache, "1")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// Transport caches responses of the round tripper it wraps.
+type Transport struct {
+	Transport http.RoundTripper // defaults to http.DefaultTransport
+	Store     Store             // defaults to a MemoryStore without a size limit
+
+	// Shared makes the cache behave like a proxy's: responses marked
+	// private aren't stored and s-maxage takes precedence over max-age.
+	Shared bool
+
+	// MaxEntrySize is the largest body stored, in bytes; 0 means no limit.
+	MaxEntrySize int64
+
+	once   sync.Once
+	memory *MemoryStore // the default Store
+}
+
+// NewTransport returns a private cache that keeps its entries in s, or in
+// memory if s is nil.
+func NewTransport(s Store) *Transport {
+	return &Transport{Store: s}
+}
+
+// Client returns an http.Client using t.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := cacheKey(req)
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		resp, err := t.transport().RoundTrip(req)
+		// unsafe methods invalidate what's stored for the target
+		if err == nil && resp.StatusCode < 400 {
+			t.store().Delete(key)
+		}
+		return resp, err
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	var cached *entry
+	if req.Method == http.MethodGet && !reqCC.has("no-store") {
+		cached = t.load(key, req)
+	}
+
+	if cached != nil && fresh(req, cached, t.Shared) {
+		return cached.response(req), nil
+	}
+	if reqCC.has("only-if-cached") {
+		return &http.Response{
+			Status:     "504 Gateway Timeout",
+			StatusCode: http.StatusGatewayTimeout,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	}
+
+	outReq := req
+	if cached != nil {
+		outReq = conditional(req, cached)
+	}
+
+	requestTime := now()
+	resp, err := t.transport().RoundTrip(outReq)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := now()
+
+	if cached != nil && resp.StatusCode == http.StatusNotModified &&
+		outReq != req && !conditionalRequest(req) {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+		cached.update(resp.Header, requestTime, responseTime)
+		t.save(key, cached)
+		return cached.response(req), nil
+	}
+
+	if !storable(req, resp, t.Shared) {
+		return resp, nil
+	}
+	e := &entry{
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Vary:         varyHeaders(req, resp.Header),
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+	}
+	resp.Body = &teeBody{
+		ReadCloser: resp.Body,
+		max:        t.MaxEntrySize,
+		done: func(body []byte) {
+			e.Body = body
+			t.save(key, e)
+		},
+	}
+	return resp, nil
+}
+
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport == nil {
+		return http.DefaultTransport
+	}
+	return t.Transport
+}
+
+func (t *Transport) store() Store {
+	if t.Store != nil {
+		return t.Store
+	}
+	t.once.Do(func() { t.memory = new(MemoryStore) })
+	return t.memory
+}
+
+func (t *Transport) load(key string, req *http.Request) *entry {
+	b, ok := t.store().Get(key)
+	if !ok {
+		return nil
+	}
+	e := new(entry)
+	if err := json.Unmarshal(b, e); err != nil {
+		t.store().Delete(key)
+		return nil
+	}
+	if !e.matches(req) {
+		return nil
+	}
+	return e
+}
+
+func (t *Transport) save(key string, e *entry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	t.store().Set(key, b)
+}
+
+func cacheKey(req *http.Request) string {
+	return req.URL.String()
+}
+
+// conditional returns a copy of req asking the origin to answer 304 if the
+// cached response is still current.
+func conditional(req *http.Request, e *entry) *http.Request {
+	etag, lm := e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+	if etag == "" && lm == "" {
+		return req
+	}
+	r := req.Clone(req.Context())
+	if etag != "" && r.Header.Get("If-None-Match") == "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	if lm != "" && r.Header.Get("If-Modified-Since") == "" {
+		r.Header.Set("If-Modified-Since", lm)
+	}
+	return r
+}
+
+// conditionalRequest reports whether the caller made req conditional itself,
+// in which case a 304 is the caller's to handle.
+func conditionalRequest(req *http.Request) bool {
+	return req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+}
+
+// update merges the headers of a 304 response into the entry (RFC 9111
+// section 3.2).
+func (e *entry) update(h http.Header, requestTime, responseTime time.Time) {
+	for name, values := range h {
+		if name == "Content-Length" {
+			continue
+		}
+		e.Header[name] = values
+	}
+	e.RequestTime, e.ResponseTime = requestTime, responseTime
+}
+
+func varyHeaders(req *http.Request, h http.Header) http.Header {
+	vary := make(http.Header)
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" {
+				vary[name] = req.Header.Values(name)
+			}
+		}
+	}
+	return vary
+}
+
+// teeBody copies the body as the caller reads it and hands the copy to done
+// once the caller has read all of it. Bodies closed early, failing, or
+// larger than max aren't stored.
+type teeBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	max  int64
+	done func([]byte)
+	once sync.Once
+	skip bool
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.skip {
+		b.buf.Write(p[:n])
+		if b.max > 0 && int64(b.buf.Len()) > b.max {
+			b.skip = true
+			b.buf = bytes.Buffer{}
+		}
+	}
+	if err != nil && err != io.EOF {
+		b.skip = true
+	}
+	if err == io.EOF && !b.skip {
+		b.once.Do(func() { b.done(b.buf.Bytes()) })
+	}
+	return n, err
+}
+
+// Close stores the body if the caller read all of it without seeing io.EOF,
+// as happens with empty bodies or readers that stop at Content-Length.
+func (b *teeBody) Close() error {
+	if !b.skip {
+		var p [1]byte
+		if n, err := b.ReadCloser.Read(p[:]); n == 0 && err == io.EOF {
+			b.once.Do(func() { b.done(b.buf.Bytes()) })
+		}
+	}
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,332 @@
+package httpcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// origin serves the handler and counts the requests reaching it.
+type origin struct {
+	*httptest.Server
+	hits int32
+}
+
+func newOrigin(t *testing.T, h http.HandlerFunc) *origin {
+	o := new(origin)
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&o.hits, 1)
+		h(w, r)
+	}))
+	t.Cleanup(o.Close)
+	return o
+}
+
+func (o *origin) Hits() int32 { return atomic.LoadInt32(&o.hits) }
+
+// clock makes now return a time the test advances.
+func clock(t *testing.T) func(time.Duration) {
+	current := time.Now()
+	now = func() time.Time { return current }
+	t.Cleanup(func() { now = time.Now })
+	return func(d time.Duration) { current = current.Add(d) }
+}
+
+func get(t *testing.T, c *http.Client, url string, header ...string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func TestMaxAge(t *testing.T) {
+	advance := clock(t)
+	o := newOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("cached"))
+	})
+	c := NewTransport(NewMemoryStore(0)).Client()
+
+	for i := 0; i < 3; i++ {
+		resp, body := get(t, c, o.URL)
+		if body != "cached" {
+			t.Fatalf("unexpected body %q", body)
+		}
+		if fromCache := resp.Header.Get(XFromCache) == "1"; fromCache != (i > 0) {
+			t.Errorf("%d: served from cache: %t", i, fromCache)
+		}
+	}
+	if o.Hits() != 1 {
+		t.Errorf("expected 1 hit; actual %d", o.Hits())
+	}
+
+	advance(30 * time.Second)
+	resp, _ := get(t, c, o.URL)
+	if age := resp.Header.Get("Age"); age != "30" {
+		t.Errorf("expected Age 30; actual %q", age)
+	}
+
+	// the client insists on a response younger than the cached one
+	get(t, c, o.URL, "Cache-Control", "max-age=10")
+	if o.Hits() != 2 {
+		t.Errorf("expected 2 hits; actual %d", o.Hits())
+	}
+
+	advance(61 * time.Second)
+	get(t, c, o.URL)
+	if o.Hits() != 3 {
+		t.Errorf("expected the stale response to be refetched; %d hits", o.Hits())
+	}
+}
+
+func TestDefaultStore(t *testing.T) {
+	o := newOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("cached"))
+	})
+	for i, tr := range []*Transport{new(Transport), NewTransport(nil)} {
+		c := tr.Client()
+		hits := o.Hits()
+		get(t, c, o.URL)
+		if resp, _ := get(t, c, o.URL); resp.Header.Get(XFromCache) != "1" {
+			t.Errorf("%d: expected the response from the cache", i)
+		}
+		if n := o.Hits() - hits; n != 1 {
+			t.Errorf("%d: expected 1 hit; actual %d", i, n)
+		}
+	}
+}
+
+func TestRevalidation(t *testing.T) {
+	advance := clock(t)
+	lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	var version int32 = 1
+	o := newOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"v%d"`, atomic.LoadInt32(&version))
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Header().Set("X-Version", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("body " + etag))
+	})
+	c := NewTransport(NewMemoryStore(0)).Client()
+
+	get(t, c, o.URL)
+	advance(time.Second)
+
+	resp, body := get(t, c, o.URL)
+	if resp.StatusCode != http.StatusOK || body != `body "v1"` {
+		t.Fatalf("expected the cached body after a 304; actual %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get(XFromCache) != "1" {
+		t.Error("expected the revalidated response to come from the cache")
+	}
+	if o.Hits() != 2 {
+		t.Errorf("expected every request to be revalidated; %d hits", o.Hits())
+	}
+
+	atomic.StoreInt32(&version, 2)
+	resp, body = get(t, c, o.URL)
+	if body != `body "v2"` || resp.Header.Get(XFromCache) != "" {
+		t.Fatalf("expected the new version from the origin; actual %q", body)
+	}
+	_, body = get(t, c, o.URL)
+	if body != `body "v2"` {
+		t.Errorf("expected the new version to replace the cached one; actual %q", body)
+	}
+
+	// a request the caller made conditional gets the origin's 304
+	resp, _ = get(t, c, o.URL, "If-None-Match", `"v2"`)
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected status %d; actual %d", http.StatusNotModified, resp.StatusCode)
+	}
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	clock(t)
+	lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	o := newOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Last-Modified", lastModified)
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("modified"))
+	})
+	c := NewTransport(NewMemoryStore(0)).Client()
+
+	get(t, c, o.URL)
+	resp, body := get(t, c, o.URL)
+	if body != "modified" || resp.Header.Get(XFromCache) != "1" {
+		t.Errorf("expected the cached body after a 304; actual %q", body)
+	}
+}
+
+func TestNotStored(t *testing.T) {
+	clock(t)
+	testCases := []struct {
+		cacheControl string
+		reqHeader    []string
+		shared       bool
+	}{
+		{cacheControl: "no-store, max-age=60"},
+		{cacheControl: "max-age=60", reqHeader: []string{"Cache-Control", "no-store"}},
+		{cacheControl: "private, max-age=60", shared: true},
+		{cacheControl: ""},
+	}
+	for _, tc := range testCases {
+		o := newOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+			if tc.cacheControl != "" {
+				w.Header().Set("Cache-Control", tc.cacheControl)
+			}
+			_, _ = w.Write([]byte("uncached"))
+		})
+		tr := NewTransport(NewMemoryStore(0))
+		tr.Shared = tc.shared
+		c := tr.Client()
+
+		get(t, c, o.URL, tc.reqHeader...)
+		get(t, c, o.URL, tc.reqHeader...)
+		if o.Hits() != 2 {
+			t.Errorf("%q shared=%t: expected 2 hits; actual %d", tc.cacheControl, tc.shared, o.Hits())
+		}
+	}
+}
+
+func TestVary(t *testing.T) {
+	clock(t)
+	o := newOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+	})
+	c := NewTransport(NewMemoryStore(0)).Client()
+
+	for _, lang := range []string{"en", "en", "fr", "fr"} {
+		if _, body := get(t, c, o.URL, "Accept-Language", lang); body != lang {
+			t.Errorf("expected %q; actual %q", lang, body)
+		}
+	}
+	// the cache keeps one variant, so switching languages misses
+	if o.Hits() != 2 {
+		t.Errorf("expected 2 hits; actual %d", o.Hits())
+	}
+}
+
+func TestUnsafeMethodInvalidates(t *testing.T) {
+	clock(t)
+	o := newOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("resource"))
+	})
+	c := NewTransport(NewMemoryStore(0)).Client()
+
+	get(t, c, o.URL)
+	resp, err := c.Post(o.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	get(t, c, o.URL)
+	if o.Hits() != 3 {
+		t.Errorf("expected the POST to invalidate the entry; %d hits", o.Hits())
+	}
+}
+
+func TestOnlyIfCachedAndMaxStale(t *testing.T) {
+	advance := clock(t)
+	o := newOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10")
+		_, _ = w.Write([]byte("resource"))
+	})
+	c := NewTransport(NewMemoryStore(0)).Client()
+
+	resp, _ := get(t, c, o.URL, "Cache-Control", "only-if-cached")
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("expected status %d; actual %d", http.StatusGatewayTimeout, resp.StatusCode)
+	}
+
+	get(t, c, o.URL)
+	advance(15 * time.Second)
+	resp, _ = get(t, c, o.URL, "Cache-Control", "max-stale=10")
+	if resp.Header.Get(XFromCache) != "1" {
+		t.Error("expected a stale response within max-stale")
+	}
+	if o.Hits() != 1 {
+		t.Errorf("expected 1 hit; actual %d", o.Hits())
+	}
+}
+
+func TestHeuristicFreshness(t *testing.T) {
+	advance := clock(t)
+	lastModified := now().Add(-10 * time.Hour).UTC().Format(http.TimeFormat)
+	o := newOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Last-Modified", lastModified)
+		_, _ = w.Write([]byte("resource"))
+	})
+	c := NewTransport(NewMemoryStore(0)).Client()
+
+	get(t, c, o.URL)
+	advance(30 * time.Minute) // within a tenth of 10 hours
+	get(t, c, o.URL)
+	if o.Hits() != 1 {
+		t.Errorf("expected 1 hit; actual %d", o.Hits())
+	}
+	advance(time.Hour)
+	get(t, c, o.URL)
+	if o.Hits() != 2 {
+		t.Errorf("expected 2 hits; actual %d", o.Hits())
+	}
+}
+
+func TestDiskStore(t *testing.T) {
+	clock(t)
+	o := newOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("persisted"))
+	})
+	dir := t.TempDir()
+
+	s, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(t, NewTransport(s).Client(), o.URL)
+
+	// a new store in the same directory, as after a restart
+	s, err = NewDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, body := get(t, NewTransport(s).Client(), o.URL)
+	if body != "persisted" || resp.Header.Get(XFromCache) != "1" {
+		t.Errorf("expected the response from disk; actual %q", body)
+	}
+	if o.Hits() != 1 {
+		t.Errorf("expected 1 hit; actual %d", o.Hits())
+	}
+}
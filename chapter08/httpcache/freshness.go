@@ -0,0 +1,173 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// now is replaced in tests.
+var now = time.Now
+
+// cacheControl holds the directives of Cache-Control headers, keyed by their
+// lowercase names. Directives without an argument map to "".
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			name, arg := d, ""
+			if i := strings.IndexByte(d, '='); i >= 0 {
+				name, arg = strings.TrimSpace(d[:i]), strings.Trim(strings.TrimSpace(d[i+1:]), `"`)
+			}
+			cc[strings.ToLower(name)] = arg
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the delta-seconds argument of the directive. A directive
+// with an invalid argument counts as absent.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// heuristicStatus lists the status codes a cache may assign a heuristic
+// freshness lifetime to (RFC 9110 section 15.1).
+var heuristicStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// storable reports whether the response to req may be stored (RFC 9111
+// section 3).
+func storable(req *http.Request, resp *http.Response, shared bool) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	reqCC, respCC := parseCacheControl(req.Header), parseCacheControl(resp.Header)
+	if reqCC.has("no-store") || respCC.has("no-store") {
+		return false
+	}
+	if shared && respCC.has("private") {
+		return false
+	}
+	if shared && req.Header.Get("Authorization") != "" &&
+		!respCC.has("public") && !respCC.has("s-maxage") && !respCC.has("must-revalidate") {
+		return false
+	}
+	if resp.Header.Get("Vary") == "*" {
+		return false
+	}
+
+	switch {
+	case respCC.has("public"), respCC.has("max-age"),
+		shared && respCC.has("s-maxage"), resp.Header.Get("Expires") != "":
+		return resp.StatusCode < 500 && resp.StatusCode != http.StatusPartialContent
+	}
+	return heuristicStatus[resp.StatusCode]
+}
+
+// freshnessLifetime returns how long the response stays fresh after it was
+// generated (RFC 9111 section 4.2.1).
+func freshnessLifetime(e *entry, shared bool) time.Duration {
+	cc := parseCacheControl(e.Header)
+	if shared {
+		if d, ok := cc.seconds("s-maxage"); ok {
+			return d
+		}
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d
+	}
+
+	date := e.date()
+	if v := e.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0 // invalid dates, like "0", mean already expired
+		}
+		return expires.Sub(date)
+	}
+
+	// a tenth of the time since the last modification, as browsers do
+	if lm, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil &&
+		heuristicStatus[e.StatusCode] && lm.Before(date) {
+		return date.Sub(lm) / 10
+	}
+	return 0
+}
+
+// currentAge returns the age of the stored response (RFC 9111 section
+// 4.2.3).
+func currentAge(e *entry) time.Duration {
+	apparent := e.ResponseTime.Sub(e.date())
+	if apparent < 0 {
+		apparent = 0
+	}
+	var age time.Duration
+	if secs, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && secs > 0 {
+		age = time.Duration(secs) * time.Second
+	}
+	corrected := age + e.ResponseTime.Sub(e.RequestTime)
+	if corrected < apparent {
+		corrected = apparent
+	}
+	return corrected + now().Sub(e.ResponseTime)
+}
+
+// fresh reports whether the stored response may be used without
+// revalidation given the request's directives.
+func fresh(req *http.Request, e *entry, shared bool) bool {
+	reqCC, respCC := parseCacheControl(req.Header), parseCacheControl(e.Header)
+	if reqCC.has("no-cache") || respCC.has("no-cache") {
+		return false
+	}
+	if req.Header.Get("Pragma") == "no-cache" && !reqCC.has("max-age") {
+		return false
+	}
+
+	lifetime, age := freshnessLifetime(e, shared), currentAge(e)
+	if d, ok := reqCC.seconds("max-age"); ok && d < lifetime {
+		lifetime = d
+	}
+	if d, ok := reqCC.seconds("min-fresh"); ok {
+		age += d
+	}
+	if age < lifetime {
+		return true
+	}
+
+	// the client accepts stale responses, unless the origin forbids it
+	if respCC.has("must-revalidate") || (shared && respCC.has("proxy-revalidate")) {
+		return false
+	}
+	if v, ok := reqCC["max-stale"]; ok {
+		if v == "" {
+			return true
+		}
+		if d, ok := reqCC.seconds("max-stale"); ok {
+			return age-lifetime < d
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package httpcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store holds serialized cache entries. Implementations must be safe for
+// concurrent use. Failing to store or delete an entry isn't an error the
+// client should see, so the methods don't return one.
+type Store interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+// MemoryStore keeps entries in memory, evicting the least recently used
+// ones once they exceed MaxBytes.
+type MemoryStore struct {
+	MaxBytes int64 // 0 means no limit
+
+	mu    sync.Mutex
+	size  int64
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	value []byte
+}
+
+// NewMemoryStore returns a MemoryStore holding up to maxBytes of entries.
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{MaxBytes: maxBytes}
+}
+
+func (m *MemoryStore) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	m.ll.MoveToFront(e)
+	return e.Value.(*memoryItem).value, true
+}
+
+func (m *MemoryStore) Set(key string, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.MaxBytes > 0 && int64(len(value)) > m.MaxBytes {
+		m.remove(key)
+		return
+	}
+	if m.items == nil {
+		m.ll = list.New()
+		m.items = make(map[string]*list.Element)
+	}
+	if e, ok := m.items[key]; ok {
+		item := e.Value.(*memoryItem)
+		m.size += int64(len(value) - len(item.value))
+		item.value = value
+		m.ll.MoveToFront(e)
+	} else {
+		m.items[key] = m.ll.PushFront(&memoryItem{key: key, value: value})
+		m.size += int64(len(value))
+	}
+	for m.MaxBytes > 0 && m.size > m.MaxBytes {
+		m.remove(m.ll.Back().Value.(*memoryItem).key)
+	}
+}
+
+func (m *MemoryStore) Delete(key string) {
+	m.mu.Lock()
+	m.remove(key)
+	m.mu.Unlock()
+}
+
+func (m *MemoryStore) remove(key string) {
+	e, ok := m.items[key]
+	if !ok {
+		return
+	}
+	m.ll.Remove(e)
+	delete(m.items, key)
+	m.size -= int64(len(e.Value.(*memoryItem).value))
+}
+
+// DiskStore keeps each entry in a file in Dir named after the SHA-256 of its
+// key, so entries survive restarts.
+type DiskStore struct {
+	Dir string
+}
+
+// NewDiskStore returns a DiskStore in dir, creating the directory if needed.
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DiskStore{Dir: dir}, nil
+}
+
+func (d *DiskStore) Get(key string) ([]byte, bool) {
+	b, err := ioutil.ReadFile(d.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// Set writes the entry to a temporary file and renames it into place, so
+// readers never see a partial entry.
+func (d *DiskStore) Set(key string, value []byte) {
+	f, err := ioutil.TempFile(d.Dir, ".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), d.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+}
+
+func (d *DiskStore) Delete(key string) {
+	_ = os.Remove(d.path(key))
+}
+
+func (d *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.Dir, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,30 @@
+package httpcache
+
+import "testing"
+
+func TestMemoryStoreEviction(t *testing.T) {
+	s := NewMemoryStore(10)
+	s.Set("a", []byte("aaaa"))
+	s.Set("b", []byte("bbbb"))
+	s.Get("a") // b is now the least recently used
+	s.Set("c", []byte("cccc"))
+
+	if _, ok := s.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := s.Get(key); !ok {
+			t.Errorf("expected %s to be kept", key)
+		}
+	}
+
+	s.Set("big", make([]byte, 11))
+	if _, ok := s.Get("big"); ok {
+		t.Error("expected an entry larger than the store not to be kept")
+	}
+
+	s.Delete("a")
+	if _, ok := s.Get("a"); ok {
+		t.Error("expected a to be deleted")
+	}
+}
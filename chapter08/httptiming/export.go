@@ -0,0 +1,119 @@
+package httptiming
+
+import (
+	"strconv"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/prometheus"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Fields returns the timings as zap fields. Durations use the logger's
+// duration encoder.
+func (t Timings) Fields() []zap.Field {
+	return []zap.Field{
+		zap.Duration("dns", t.DNS),
+		zap.Duration("connect", t.Connect),
+		zap.Duration("tls_handshake", t.TLSHandshake),
+		zap.Duration("conn_wait", t.ConnWait),
+		zap.Duration("server_processing", t.ServerProcessing),
+		zap.Duration("first_byte", t.FirstByte),
+		zap.Duration("transfer", t.Transfer),
+		zap.Duration("total", t.Total),
+		zap.Bool("reused", t.Reused),
+		zap.String("remote_addr", t.RemoteAddr),
+	}
+}
+
+// MarshalLogObject lets the timings be logged as one nested object with
+// zap.Object.
+func (t Timings) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, f := range t.Fields() {
+		f.AddTo(enc)
+	}
+	return nil
+}
+
+// Histograms records timings into a histogram labeled by phase and counts
+// requests by whether their connection was reused.
+type Histograms struct {
+	Phases   metrics.Histogram // label "phase"
+	Requests metrics.Counter   // label "reused"
+}
+
+// NewPrometheusHistograms registers the histograms with reg, or with the
+// default Prometheus registry if reg is nil, as
+// chapter13/instrumentation/metrics does. Histograms for the same namespace
+// and subsystem share the collectors registered first, so every client
+// reporting to one registry may call it.
+func NewPrometheusHistograms(reg prom.Registerer, namespace, subsystem string) (*Histograms, error) {
+	if reg == nil {
+		reg = prom.DefaultRegisterer
+	}
+	phases := prom.NewHistogramVec(
+		prom.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Buckets:   prom.ExponentialBuckets(0.0005, 2, 16), // 0.5ms to 16s
+			Name:      "client_request_phase_seconds",
+			Help:      "Duration of the phases of outgoing HTTP requests",
+		},
+		[]string{"phase"},
+	)
+	if err := reg.Register(phases); err != nil {
+		existing, ok := err.(prom.AlreadyRegisteredError)
+		if !ok {
+			return nil, err
+		}
+		if phases, ok = existing.ExistingCollector.(*prom.HistogramVec); !ok {
+			return nil, err
+		}
+	}
+	requests := prom.NewCounterVec(
+		prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "client_requests_total",
+			Help:      "Total outgoing HTTP requests",
+		},
+		[]string{"reused"},
+	)
+	if err := reg.Register(requests); err != nil {
+		existing, ok := err.(prom.AlreadyRegisteredError)
+		if !ok {
+			return nil, err
+		}
+		if requests, ok = existing.ExistingCollector.(*prom.CounterVec); !ok {
+			return nil, err
+		}
+	}
+	return &Histograms{
+		Phases:   prometheus.NewHistogram(phases),
+		Requests: prometheus.NewCounter(requests),
+	}, nil
+}
+
+// Observe records t. Phases that didn't happen aren't observed, so reused
+// connections don't drag the connect histogram toward zero.
+func (h *Histograms) Observe(t Timings) {
+	for _, p := range []struct {
+		name string
+		d    float64
+	}{
+		{"dns", t.DNS.Seconds()},
+		{"connect", t.Connect.Seconds()},
+		{"tls_handshake", t.TLSHandshake.Seconds()},
+		{"conn_wait", t.ConnWait.Seconds()},
+		{"server_processing", t.ServerProcessing.Seconds()},
+		{"first_byte", t.FirstByte.Seconds()},
+		{"transfer", t.Transfer.Seconds()},
+		{"total", t.Total.Seconds()},
+	} {
+		if p.d > 0 {
+			h.Phases.With("phase", p.name).Observe(p.d)
+		}
+	}
+	h.Requests.With("reused", strconv.FormatBool(t.Reused)).Add(1)
+}
@@ -0,0 +1,181 @@
+// Package httptiming breaks the time an HTTP request takes into its phases
+// using net/http/httptrace, and exports the breakdown as zap fields or
+// Prometheus histograms.
+package httptiming
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings is the breakdown of one request. Phases that didn't happen, such
+// as DNS resolution for a reused connection, are zero.
+type Timings struct {
+	DNS          time.Duration // resolving the host name
+	Connect      time.Duration // establishing the TCP connection
+	TLSHandshake time.Duration
+	// ConnWait is the time until the transport had a connection, which
+	// includes DNS, Connect and TLSHandshake for new connections and any
+	// wait for a free connection in the pool.
+	ConnWait time.Duration
+	// ServerProcessing is the time between writing the request and
+	// receiving the first response byte.
+	ServerProcessing time.Duration
+	FirstByte        time.Duration // time to the first response byte
+	Transfer         time.Duration // reading the response body
+	Total            time.Duration
+
+	Reused     bool          // the connection came from the pool
+	WasIdle    bool          // the reused connection was idle
+	IdleTime   time.Duration // how long the reused connection was idle
+	RemoteAddr string
+}
+
+// Trace records the timestamps of one request's phases. Its methods are safe
+// to call concurrently with the transport's hooks.
+type Trace struct {
+	mu sync.Mutex
+
+	start, getConn, gotConn       time.Time
+	dnsStart, dnsDone             time.Time
+	connectStart, connectDone     time.Time
+	tlsStart, tlsDone             time.Time
+	wroteRequest, firstByte, done time.Time
+	reused, wasIdle               bool
+	idleTime                      time.Duration
+	remoteAddr                    string
+}
+
+// WithTrace returns a context that records the phases of requests made with
+// it into the returned Trace, starting now. Use one per request.
+func WithTrace(ctx context.Context) (context.Context, *Trace) {
+	t := &Trace{start: time.Now()}
+	return httptrace.WithClientTrace(ctx, t.clientTrace()), t
+}
+
+func (t *Trace) clientTrace() *httptrace.ClientTrace {
+	set := func(field *time.Time) {
+		t.mu.Lock()
+		*field = time.Now()
+		t.mu.Unlock()
+	}
+	// only the first of several attempts, as with dual-stack dialing,
+	// marks the start of a phase
+	setFirst := func(field *time.Time) {
+		t.mu.Lock()
+		if field.IsZero() {
+			*field = time.Now()
+		}
+		t.mu.Unlock()
+	}
+
+	return &httptrace.ClientTrace{
+		GetConn: func(string) { setFirst(&t.getConn) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.gotConn = time.Now()
+			t.reused, t.wasIdle, t.idleTime = info.Reused, info.WasIdle, info.IdleTime
+			if info.Conn != nil {
+				t.remoteAddr = info.Conn.RemoteAddr().String()
+			}
+			t.mu.Unlock()
+		},
+		DNSStart:             func(httptrace.DNSStartInfo) { setFirst(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { set(&t.dnsDone) },
+		ConnectStart:         func(string, string) { setFirst(&t.connectStart) },
+		ConnectDone:          func(string, string, error) { set(&t.connectDone) },
+		TLSHandshakeStart:    func() { setFirst(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { set(&t.tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { set(&t.wroteRequest) },
+		GotFirstResponseByte: func() { setFirst(&t.firstByte) },
+	}
+}
+
+// Finish marks the end of the request, if it isn't marked yet, and returns
+// the breakdown. Call it once the response body is read.
+func (t *Trace) Finish() Timings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done.IsZero() {
+		t.done = time.Now()
+	}
+
+	return Timings{
+		DNS:              between(t.dnsStart, t.dnsDone),
+		Connect:          between(t.connectStart, t.connectDone),
+		TLSHandshake:     between(t.tlsStart, t.tlsDone),
+		ConnWait:         between(t.getConn, t.gotConn),
+		ServerProcessing: between(t.wroteRequest, t.firstByte),
+		FirstByte:        between(t.start, t.firstByte),
+		Transfer:         between(t.firstByte, t.done),
+		Total:            between(t.start, t.done),
+		Reused:           t.reused,
+		WasIdle:          t.wasIdle,
+		IdleTime:         t.idleTime,
+		RemoteAddr:       t.remoteAddr,
+	}
+}
+
+// between returns the time from start to end, or zero if either is unknown.
+func between(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// Transport traces every request it sends and passes the timings to Observe
+// once the response body is read to the end or closed, or right away if the
+// request fails.
+type Transport struct {
+	Transport http.RoundTripper // defaults to http.DefaultTransport
+	Observe   func(req *http.Request, resp *http.Response, t Timings)
+}
+
+func (tr *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, trace := WithTrace(req.Context())
+	rt := tr.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	resp, err := rt.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		if tr.Observe != nil {
+			tr.Observe(req, nil, trace.Finish())
+		}
+		return nil, err
+	}
+	resp.Body = &observedBody{ReadCloser: resp.Body, finish: func() {
+		timings := trace.Finish()
+		if tr.Observe != nil {
+			tr.Observe(req, resp, timings)
+		}
+	}}
+	return resp, nil
+}
+
+type observedBody struct {
+	io.ReadCloser
+	once   sync.Once
+	finish func()
+}
+
+func (b *observedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.finish)
+	}
+	return n, err
+}
+
+func (b *observedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.finish)
+	return err
+}
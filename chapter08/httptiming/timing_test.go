@@ -0,0 +1,192 @@
+package httptiming
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.(http.Flusher).Flush()
+		time.Sleep(10 * time.Millisecond)
+		_, _ = w.Write([]byte("timed"))
+	}))
+	defer srv.Close()
+
+	var timings []Timings
+	c := &http.Client{Transport: &Transport{
+		Transport: srv.Client().Transport,
+		Observe: func(_ *http.Request, _ *http.Response, t Timings) {
+			timings = append(timings, t)
+		},
+	}}
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}
+	if len(timings) != 2 {
+		t.Fatalf("expected 2 observations; actual %d", len(timings))
+	}
+
+	first, second := timings[0], timings[1]
+	if first.Reused || first.Connect <= 0 || first.TLSHandshake <= 0 {
+		t.Errorf("expected a new connection with a handshake: %+v", first)
+	}
+	if first.ServerProcessing < 10*time.Millisecond || first.Transfer < 10*time.Millisecond {
+		t.Errorf("expected the server's delays to show: %+v", first)
+	}
+	if first.Total < first.FirstByte+first.Transfer-time.Millisecond {
+		t.Errorf("total doesn't cover the phases: %+v", first)
+	}
+	if !second.Reused || second.Connect != 0 || second.TLSHandshake != 0 {
+		t.Errorf("expected a reused connection: %+v", second)
+	}
+	if second.RemoteAddr != strings.TrimPrefix(srv.URL, "https://") {
+		t.Errorf("unexpected remote address %q", second.RemoteAddr)
+	}
+}
+
+func TestWithTraceDNS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	ctx, trace := WithTrace(context.Background())
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	timings := trace.Finish()
+	if timings.DNS <= 0 {
+		t.Errorf("expected the host name to be resolved: %+v", timings)
+	}
+	if timings.TLSHandshake != 0 {
+		t.Errorf("expected no TLS handshake: %+v", timings)
+	}
+	if again := trace.Finish(); again.Total != timings.Total {
+		t.Error("Finish moved the end of the request")
+	}
+}
+
+func TestFields(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	timings := Timings{DNS: time.Millisecond, Total: 5 * time.Millisecond, Reused: true}
+	zap.New(core).Info("request", timings.Fields()...)
+	zap.New(core).Info("request", zap.Object("timings", timings))
+
+	fields := logs.All()[0].ContextMap()
+	if fields["dns"] != time.Millisecond || fields["total"] != 5*time.Millisecond || fields["reused"] != true {
+		t.Errorf("unexpected fields %v", fields)
+	}
+	nested, ok := logs.All()[1].ContextMap()["timings"].(map[string]interface{})
+	if !ok || nested["total"] != 5*time.Millisecond {
+		t.Errorf("unexpected nested fields %v", logs.All()[1].ContextMap())
+	}
+}
+
+func TestHistograms(t *testing.T) {
+	// a subsystem of its own, since the default registry outlives the test
+	subsystem := fmt.Sprintf("httptiming%d", time.Now().UnixNano())
+	h, err := NewPrometheusHistograms(nil, "test", subsystem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Observe(Timings{Connect: 2 * time.Millisecond, Total: 10 * time.Millisecond})
+	// a second client reporting to the same registry
+	other, err := NewPrometheusHistograms(nil, "test", subsystem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Observe(Timings{Total: 4 * time.Millisecond, Reused: true})
+
+	families, err := prom.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	phases := make(map[string]uint64)
+	requests := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			if len(m.GetLabel()) != 1 {
+				continue
+			}
+			label := m.GetLabel()[0].GetValue()
+			switch mf.GetName() {
+			case "test_" + subsystem + "_client_request_phase_seconds":
+				phases[label] = m.GetHistogram().GetSampleCount()
+			case "test_" + subsystem + "_client_requests_total":
+				requests[label] = m.GetCounter().GetValue()
+			}
+		}
+	}
+
+	if phases["total"] != 2 || phases["connect"] != 1 {
+		t.Errorf("unexpected phase counts %v", phases)
+	}
+	if _, ok := phases["dns"]; ok {
+		t.Error("phases that didn't happen shouldn't be observed")
+	}
+	if requests["true"] != 1 || requests["false"] != 1 {
+		t.Errorf("unexpected request counts %v", requests)
+	}
+}
+
+func TestHistogramsRegistry(t *testing.T) {
+	reg := prom.NewRegistry()
+	for i := 0; i < 2; i++ {
+		h, err := NewPrometheusHistograms(reg, "test", "httptiming")
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Observe(Timings{Total: time.Millisecond})
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total float64
+	for _, mf := range families {
+		if mf.GetName() == "test_httptiming_client_requests_total" {
+			for _, m := range mf.GetMetric() {
+				total += m.GetCounter().GetValue()
+			}
+		}
+	}
+	if total != 2 {
+		t.Errorf("expected 2 requests in the registry; actual %v", total)
+	}
+
+	// a different metric by the same name
+	reg = prom.NewRegistry()
+	reg.MustRegister(prom.NewCounter(prom.CounterOpts{
+		Namespace: "test", Subsystem: "httptiming", Name: "client_requests_total",
+		Help: "something else",
+	}))
+	if _, err = NewPrometheusHistograms(reg, "test", "httptiming"); err == nil {
+		t.Error("expected an error for a conflicting metric")
+	}
+}
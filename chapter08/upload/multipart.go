@@ -0,0 +1,244 @@
+// Package upload sends large files over HTTP without holding them in
+// memory: Multipart streams a multipart/form-data body through an io.Pipe,
+// and Uploads together with Uploader implement resumable chunked uploads.
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Field is a plain form value.
+type Field struct {
+	Name, Value string
+}
+
+// File is a file part. Open is called each time the body is generated, so a
+// request built by Multipart can be resent.
+type File struct {
+	Field    string // form field name
+	Filename string
+	Size     int64 // 0 or -1 if unknown, which leaves the body's length unknown
+	Open     func() (io.ReadCloser, error)
+}
+
+// FileFromPath returns a File that reads path.
+func FileFromPath(field, path string) (File, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return File{}, err
+	}
+	if !fi.Mode().IsRegular() {
+		return File{}, errors.New("upload: not a regular file: " + path)
+	}
+	return File{
+		Field:    field,
+		Filename: filepath.Base(path),
+		Size:     fi.Size(),
+		Open:     func() (io.ReadCloser, error) { return os.Open(path) },
+	}, nil
+}
+
+// Multipart builds a multipart/form-data body that is written as it's read,
+// so only a small buffer of each file is ever in memory.
+type Multipart struct {
+	Fields []Field
+	Files  []File
+
+	// Progress, if set, is called from the writing goroutine after each
+	// write with the bytes written so far and the total, which is -1 if a
+	// file's size is unknown.
+	Progress func(written, total int64)
+	// Hash creates the checksum computed over each file's contents.
+	// Defaults to sha256.New.
+	Hash func() hash.Hash
+
+	boundary string
+	mu       sync.Mutex
+	sums     []string
+}
+
+// Request returns a request whose body streams m. GetBody regenerates the
+// body, so clients that retry requests can resend it.
+func (m *Multipart) Request(ctx context.Context, method, url string) (*http.Request, error) {
+	total, err := m.Len()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, m.Reader())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", m.FormDataContentType())
+	req.ContentLength = total
+	req.GetBody = func() (io.ReadCloser, error) { return m.Reader(), nil }
+	return req, nil
+}
+
+// FormDataContentType returns the Content-Type of the body, including its
+// boundary.
+func (m *Multipart) FormDataContentType() string {
+	return "multipart/form-data; boundary=" + m.getBoundary()
+}
+
+func (m *Multipart) getBoundary() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.boundary == "" {
+		m.boundary = multipart.NewWriter(io.Discard).Boundary()
+	}
+	return m.boundary
+}
+
+// Len returns the length of the body without reading any file, or -1 if a
+// file's size is unknown.
+func (m *Multipart) Len() (int64, error) {
+	c := new(countingWriter)
+	w, err := m.writer(c)
+	if err != nil {
+		return 0, err
+	}
+	if err = m.writeFields(w); err != nil {
+		return 0, err
+	}
+	for _, f := range m.Files {
+		if f.Size <= 0 {
+			return -1, nil
+		}
+		if _, err = w.CreateFormFile(f.Field, f.Filename); err != nil {
+			return 0, err
+		}
+		c.n += f.Size
+	}
+	if err = w.Close(); err != nil {
+		return 0, err
+	}
+	return c.n, nil
+}
+
+// Reader returns a new reader of the body. Closing it before reaching the
+// end stops the writing goroutine.
+func (m *Multipart) Reader() io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(m.write(pw))
+	}()
+	return pr
+}
+
+// Checksums returns the hex-encoded checksum of each file, in the order of
+// Files, as computed the last time a body was read to the end. It returns nil
+// until then.
+func (m *Multipart) Checksums() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.sums...)
+}
+
+func (m *Multipart) writer(out io.Writer) (*multipart.Writer, error) {
+	w := multipart.NewWriter(out)
+	return w, w.SetBoundary(m.getBoundary())
+}
+
+func (m *Multipart) writeFields(w *multipart.Writer) error {
+	for _, f := range m.Fields {
+		if err := w.WriteField(f.Name, f.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Multipart) write(out io.Writer) error {
+	total, err := m.Len()
+	if err != nil {
+		return err
+	}
+	if m.Progress != nil {
+		out = &progressWriter{w: out, total: total, fn: m.Progress}
+	}
+
+	w, err := m.writer(out)
+	if err != nil {
+		return err
+	}
+	if err = m.writeFields(w); err != nil {
+		return err
+	}
+
+	sums := make([]string, len(m.Files))
+	for i, f := range m.Files {
+		part, err := w.CreateFormFile(f.Field, f.Filename)
+		if err != nil {
+			return err
+		}
+		sum, err := m.copyFile(part, f)
+		if err != nil {
+			return err
+		}
+		sums[i] = sum
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.sums = sums
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Multipart) copyFile(part io.Writer, f File) (string, error) {
+	r, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	newHash := m.Hash
+	if newHash == nil {
+		newHash = sha256.New
+	}
+	h := newHash()
+	n, err := io.Copy(io.MultiWriter(part, h), r)
+	if err != nil {
+		return "", err
+	}
+	if f.Size > 0 && n != f.Size {
+		// the announced Content-Length would be wrong
+		return "", errors.New("upload: size of " + f.Filename + " changed")
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+type progressWriter struct {
+	w       io.Writer
+	written int64
+	total   int64
+	fn      func(written, total int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	p.fn(p.written, p.total)
+	return n, err
+}
@@ -0,0 +1,215 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMultipart(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100000)
+	path := filepath.Join(t.TempDir(), "large.bin")
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := FileFromPath("file1", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received [32]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength <= int64(len(content)) {
+			t.Errorf("unexpected Content-Length %d", r.ContentLength)
+		}
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			switch p.FormName() {
+			case "description":
+				b, _ := ioutil.ReadAll(p)
+				if string(b) != "large file" {
+					t.Errorf("unexpected description %q", b)
+				}
+			case "file1":
+				if p.FileName() != "large.bin" {
+					t.Errorf("unexpected file name %q", p.FileName())
+				}
+				h := sha256.New()
+				_, _ = io.Copy(h, p)
+				copy(received[:], h.Sum(nil))
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	var last, total int64
+	m := &Multipart{
+		Fields: []Field{{Name: "description", Value: "large file"}},
+		Files:  []File{file},
+		Progress: func(written, t int64) {
+			last, total = written, t
+		},
+	}
+	req, err := m.Request(context.Background(), http.MethodPost, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status %s", resp.Status)
+	}
+
+	if total != req.ContentLength || last != total {
+		t.Errorf("progress ended at %d of %d; body is %d bytes", last, total, req.ContentLength)
+	}
+	expected := sha256.Sum256(content)
+	if sums := m.Checksums(); len(sums) != 1 || sums[0] != hex.EncodeToString(expected[:]) {
+		t.Errorf("unexpected checksums %v", sums)
+	}
+	if received != expected {
+		t.Error("server received different content")
+	}
+
+	// GetBody regenerates an identical body
+	body, err := req.GetBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(ioutil.Discard, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != req.ContentLength {
+		t.Errorf("regenerated body is %d bytes; expected %d", n, req.ContentLength)
+	}
+}
+
+func TestMultipartChecksumsSameField(t *testing.T) {
+	bytesFile := func(name, content string) File {
+		return File{Field: "attachments", Filename: name, Size: int64(len(content)),
+			Open: func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader([]byte(content))), nil
+			},
+		}
+	}
+	m := &Multipart{Files: []File{bytesFile("a.txt", "first"), bytesFile("b.txt", "second")}}
+	if sums := m.Checksums(); sums != nil {
+		t.Errorf("expected no checksums before the body is read; actual %v", sums)
+	}
+	if _, err := io.Copy(ioutil.Discard, m.Reader()); err != nil {
+		t.Fatal(err)
+	}
+
+	sums := m.Checksums()
+	if len(sums) != 2 {
+		t.Fatalf("expected 2 checksums; actual %v", sums)
+	}
+	for i, content := range []string{"first", "second"} {
+		expected := sha256.Sum256([]byte(content))
+		if sums[i] != hex.EncodeToString(expected[:]) {
+			t.Errorf("file %d: unexpected checksum %s", i, sums[i])
+		}
+	}
+}
+
+func TestMultipartUnknownSize(t *testing.T) {
+	// a Size left unset means unknown, like -1
+	for _, size := range []int64{-1, 0} {
+		m := &Multipart{Files: []File{{
+			Field:    "file",
+			Filename: "stream",
+			Size:     size,
+			Open: func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader([]byte("stream"))), nil
+			},
+		}}}
+		n, err := m.Len()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != -1 {
+			t.Errorf("size %d: expected unknown length; actual %d", size, n)
+		}
+		if _, err = io.Copy(ioutil.Discard, m.Reader()); err != nil {
+			t.Errorf("size %d: %v", size, err)
+		}
+	}
+}
+
+func TestMultipartSizeChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(path, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := FileFromPath("file", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path, []byte("longer now"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = io.Copy(ioutil.Discard, (&Multipart{Files: []File{file}}).Reader())
+	if err == nil {
+		t.Fatal("expected an error for a file whose size changed")
+	}
+}
+
+func TestMultipartReaderClosedEarly(t *testing.T) {
+	opened := make(chan *os.File, 1)
+	path := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(path, bytes.Repeat([]byte("x"), 1<<20), 0600); err != nil {
+		t.Fatal(err)
+	}
+	m := &Multipart{Files: []File{{
+		Field: "file", Filename: "file", Size: 1 << 20,
+		Open: func() (io.ReadCloser, error) {
+			f, err := os.Open(path)
+			opened <- f
+			return f, err
+		},
+	}}}
+
+	r := m.Reader()
+	// read past the part headers into the file's contents
+	if _, err := io.ReadFull(r, make([]byte, 4096)); err != nil {
+		t.Fatal(err)
+	}
+	_ = r.Close()
+
+	// the writing goroutine gives up and closes the file
+	f := <-opened
+	for i := 0; i < 100; i++ {
+		if _, err := f.Stat(); err != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("file wasn't closed after the reader was")
+}
@@ -0,0 +1,508 @@
+package upload
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The resumable upload protocol follows the core of tus 1.0 (tus.io):
+//
+//	POST   <base>        Upload-Length: n   creates an upload; 201 with Location
+//	HEAD   <base>/<id>                      Upload-Offset and Upload-Length
+//	PATCH  <base>/<id>   Upload-Offset: o   appends the body at offset o
+//	DELETE <base>/<id>                      discards the upload
+//
+// PATCH requests carry Content-Type: application/offset+octet-stream and
+// may carry Upload-Checksum: sha256 <base64 digest of the chunk>.
+const (
+	TusResumable     = "1.0.0"
+	OffsetOctetType  = "application/offset+octet-stream"
+	DefaultChunkSize = 8 << 20
+
+	// StatusChecksumMismatch is tus' status for a chunk whose
+	// Upload-Checksum doesn't match its contents.
+	StatusChecksumMismatch = 460
+)
+
+var (
+	ErrOffsetMismatch   = errors.New("upload: offset mismatch")
+	ErrChecksumMismatch = errors.New("upload: checksum mismatch")
+)
+
+// Uploads stores resumable uploads in Dir. An upload with ID id is written
+// to id.part, with its metadata in id.json, and renamed to id once all of it
+// has arrived. The metadata stays, marked complete, so HEAD tells a client
+// whose last PATCH response got lost that the server has every byte. Mount
+// it with http.StripPrefix so the ID is the request path.
+type Uploads struct {
+	Dir     string
+	MaxSize int64 // largest accepted Upload-Length; 0 means no limit
+
+	// OnComplete, if set, is called with the upload's ID and final path
+	// once its last byte is written.
+	OnComplete func(id, path string)
+	Logger     *log.Logger // defaults to discarding log output
+
+	mu   sync.Mutex
+	busy map[string]bool // uploads with a PATCH in progress
+}
+
+type uploadInfo struct {
+	Length   int64 `json:"length"`
+	Complete bool  `json:"complete,omitempty"`
+}
+
+func (u *Uploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", TusResumable)
+	id := strings.Trim(r.URL.Path, "/")
+
+	switch {
+	case r.Method == http.MethodOptions:
+		w.Header().Set("Tus-Version", TusResumable)
+		w.Header().Set("Tus-Extension", "creation,termination,checksum")
+		w.Header().Set("Tus-Checksum-Algorithm", "sha256")
+		if u.MaxSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(u.MaxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case id == "" && r.Method == http.MethodPost:
+		u.create(w, r)
+	case id == "" || !validID(id):
+		http.NotFound(w, r)
+	case r.Method == http.MethodHead:
+		u.head(w, id)
+	case r.Method == http.MethodPatch:
+		u.patch(w, r, id)
+	case r.Method == http.MethodDelete:
+		u.delete(w, id)
+	default:
+		w.Header().Set("Allow", "DELETE, HEAD, OPTIONS, PATCH, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func (u *Uploads) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if u.MaxSize > 0 && length > u.MaxSize {
+		http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		u.fail(w, "generating upload ID", err)
+		return
+	}
+	id := hex.EncodeToString(b)
+
+	info := uploadInfo{Length: length}
+	err = u.writeInfo(id, info)
+	if err == nil {
+		err = ioutil.WriteFile(u.path(id, ".part"), nil, 0600)
+	}
+	if err == nil && length == 0 {
+		err = u.complete(id, info)
+	}
+	if err != nil {
+		u.fail(w, "creating upload", err)
+		return
+	}
+
+	// the request path, before any StripPrefix, locates the new upload
+	base := r.URL.Path
+	if reqURL, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		base = reqURL.Path
+	}
+	w.Header().Set("Location", path.Join("/", base, id))
+	w.Header().Set("Upload-Offset", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (u *Uploads) head(w http.ResponseWriter, id string) {
+	info, offset, err := u.state(id)
+	if err != nil {
+		u.notFoundOrFail(w, id, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (u *Uploads) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != OffsetOctetType {
+		http.Error(w, "Expected "+OffsetOctetType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	var sum []byte
+	if v := r.Header.Get("Upload-Checksum"); v != "" {
+		algo, digest := splitChecksum(v)
+		if algo != "sha256" {
+			http.Error(w, "Unsupported checksum algorithm", http.StatusBadRequest)
+			return
+		}
+		if sum, err = base64.StdEncoding.DecodeString(digest); err != nil {
+			http.Error(w, "Invalid Upload-Checksum", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !u.lock(id) {
+		http.Error(w, "Upload in progress", http.StatusConflict)
+		return
+	}
+	defer u.unlock(id)
+
+	info, current, err := u.state(id)
+	if err != nil {
+		u.notFoundOrFail(w, id, err)
+		return
+	}
+	if offset != current {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+		http.Error(w, ErrOffsetMismatch.Error(), http.StatusConflict)
+		return
+	}
+	if info.Complete {
+		// nothing left to write
+		w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	f, err := os.OpenFile(u.path(id, ".part"), os.O_WRONLY, 0)
+	if err != nil {
+		u.fail(w, "opening upload", err)
+		return
+	}
+	defer f.Close()
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		u.fail(w, "opening upload", err)
+		return
+	}
+
+	// Without a checksum, whatever arrives before the client disconnects
+	// is kept so it can resume from there. With one, the chunk counts only
+	// if all of it arrived intact.
+	h := sha256.New()
+	remaining := info.Length - offset
+	n, copyErr := io.Copy(io.MultiWriter(f, h), io.LimitReader(r.Body, remaining+1))
+
+	switch {
+	case n > remaining:
+		_ = f.Truncate(offset)
+		http.Error(w, "Chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	case sum != nil && copyErr == nil && string(h.Sum(nil)) != string(sum):
+		_ = f.Truncate(offset)
+		http.Error(w, ErrChecksumMismatch.Error(), StatusChecksumMismatch)
+		return
+	case sum != nil && copyErr != nil:
+		_ = f.Truncate(offset)
+		n = 0
+	}
+	if copyErr != nil {
+		u.logger().Printf("upload %s: reading chunk at offset %d: %v", id, offset, copyErr)
+		// the client is most likely gone, but tell it where to resume
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset+n, 10))
+		http.Error(w, "Incomplete chunk", http.StatusBadRequest)
+		return
+	}
+
+	if err = f.Sync(); err != nil {
+		u.fail(w, "writing upload", err)
+		return
+	}
+	offset += n
+	if offset == info.Length {
+		if err = f.Close(); err == nil {
+			err = u.complete(id, info)
+		}
+		if err != nil {
+			u.fail(w, "completing upload", err)
+			return
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (u *Uploads) delete(w http.ResponseWriter, id string) {
+	if !u.lock(id) {
+		http.Error(w, "Upload in progress", http.StatusConflict)
+		return
+	}
+	defer u.unlock(id)
+
+	err := os.Remove(u.path(id, ".json"))
+	if err != nil {
+		u.notFoundOrFail(w, id, err)
+		return
+	}
+	// a completed upload's file is OnComplete's to keep or remove
+	_ = os.Remove(u.path(id, ".part"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// state returns the upload's metadata and the number of bytes received,
+// which for a completed upload is all of them.
+func (u *Uploads) state(id string) (uploadInfo, int64, error) {
+	var info uploadInfo
+	b, err := ioutil.ReadFile(u.path(id, ".json"))
+	if err != nil {
+		return info, 0, err
+	}
+	if err = json.Unmarshal(b, &info); err != nil {
+		return info, 0, err
+	}
+	if info.Complete {
+		// the file itself may have been moved by OnComplete
+		return info, info.Length, nil
+	}
+	fi, err := os.Stat(u.path(id, ".part"))
+	if err != nil {
+		return info, 0, err
+	}
+	return info, fi.Size(), nil
+}
+
+func (u *Uploads) writeInfo(id string, info uploadInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(u.path(id, ".json"), b, 0600)
+}
+
+func (u *Uploads) complete(id string, info uploadInfo) error {
+	final := u.path(id, "")
+	if err := os.Rename(u.path(id, ".part"), final); err != nil {
+		return err
+	}
+	info.Complete = true
+	if err := u.writeInfo(id, info); err != nil {
+		return err
+	}
+	if u.OnComplete != nil {
+		u.OnComplete(id, final)
+	}
+	return nil
+}
+
+func (u *Uploads) path(id, ext string) string {
+	return filepath.Join(u.Dir, id+ext)
+}
+
+func (u *Uploads) lock(id string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.busy[id] {
+		return false
+	}
+	if u.busy == nil {
+		u.busy = make(map[string]bool)
+	}
+	u.busy[id] = true
+	return true
+}
+
+func (u *Uploads) unlock(id string) {
+	u.mu.Lock()
+	delete(u.busy, id)
+	u.mu.Unlock()
+}
+
+func (u *Uploads) notFoundOrFail(w http.ResponseWriter, id string, err error) {
+	if os.IsNotExist(err) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	u.fail(w, "upload "+id, err)
+}
+
+func (u *Uploads) fail(w http.ResponseWriter, what string, err error) {
+	u.logger().Printf("%s: %v", what, err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+func (u *Uploads) logger() *log.Logger {
+	if u.Logger == nil {
+		return log.New(ioutil.Discard, "", 0)
+	}
+	return u.Logger
+}
+
+func splitChecksum(v string) (algo, digest string) {
+	i := strings.IndexByte(v, ' ')
+	if i < 0 {
+		return v, ""
+	}
+	return v[:i], v[i+1:]
+}
+
+// Doer sends HTTP requests; *http.Client and the chapter08 client package's
+// Client both implement it.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Uploader sends files to an Uploads handler in checksummed chunks,
+// resuming from the offset the server reports.
+type Uploader struct {
+	Client    Doer  // defaults to http.DefaultClient
+	ChunkSize int64 // defaults to DefaultChunkSize
+
+	// Progress, if set, is called after each chunk with the bytes the
+	// server has acknowledged and the total size.
+	Progress func(offset, size int64)
+}
+
+// Create starts an upload of size bytes at endpoint and returns its URL.
+func (up *Uploader) Create(ctx context.Context, endpoint string, size int64) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Tus-Resumable", TusResumable)
+	req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+
+	resp, err := up.do(req, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	loc, err := resp.Location()
+	if err != nil {
+		return "", err
+	}
+	return loc.String(), nil
+}
+
+// Offset returns the number of bytes the server has of the upload at
+// location.
+func (up *Uploader) Offset(ctx context.Context, location string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, location, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Tus-Resumable", TusResumable)
+	resp, err := up.do(req, http.StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// Upload sends what the server doesn't have yet of the size bytes in r to
+// the upload at location. After an error, calling Upload again resumes it.
+func (up *Uploader) Upload(ctx context.Context, location string, r io.ReaderAt, size int64) error {
+	offset, err := up.Offset(ctx, location)
+	if err != nil {
+		return err
+	}
+
+	chunkSize := up.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	for offset < size {
+		n := size - offset
+		if n > chunkSize {
+			n = chunkSize
+		}
+		if offset, err = up.patch(ctx, location, io.NewSectionReader(r, offset, n), offset, n); err != nil {
+			return err
+		}
+		if up.Progress != nil {
+			up.Progress(offset, size)
+		}
+	}
+	return nil
+}
+
+func (up *Uploader) patch(ctx context.Context, location string, chunk *io.SectionReader, offset, n int64) (int64, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, chunk); err != nil {
+		return offset, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, location,
+		io.NewSectionReader(chunk, 0, n))
+	if err != nil {
+		return offset, err
+	}
+	req.ContentLength = n
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(io.NewSectionReader(chunk, 0, n)), nil
+	}
+	req.Header.Set("Tus-Resumable", TusResumable)
+	req.Header.Set("Content-Type", OffsetOctetType)
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	req.Header.Set("Upload-Checksum",
+		"sha256 "+base64.StdEncoding.EncodeToString(h.Sum(nil)))
+
+	resp, err := up.do(req, http.StatusNoContent)
+	if err != nil {
+		return offset, err
+	}
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// do sends req and returns the response, with its body closed, if it has the
+// expected status.
+func (up *Uploader) do(req *http.Request, expected int) (*http.Response, error) {
+	c := up.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4<<10))
+	_ = resp.Body.Close()
+
+	switch resp.StatusCode {
+	case expected:
+		return resp, nil
+	case http.StatusConflict:
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL, ErrOffsetMismatch)
+	case StatusChecksumMismatch:
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL, ErrChecksumMismatch)
+	}
+	return nil, fmt.Errorf("%s %s: unexpected status %s", req.Method, req.URL, resp.Status)
+}
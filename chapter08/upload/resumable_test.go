@@ -0,0 +1,251 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newUploadServer(t *testing.T) (*httptest.Server, *Uploads, chan string) {
+	completed := make(chan string, 1)
+	u := &Uploads{
+		Dir:        t.TempDir(),
+		MaxSize:    1 << 20,
+		OnComplete: func(_, path string) { completed <- path },
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/files/", http.StripPrefix("/files", u))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, u, completed
+}
+
+// failingReaderAt fails reads reaching past fail, unless fail is negative.
+type failingReaderAt struct {
+	r    io.ReaderAt
+	fail int64
+}
+
+func (f *failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if f.fail >= 0 && off+int64(len(p)) > f.fail {
+		return 0, errors.New("disk error")
+	}
+	return f.r.ReadAt(p, off)
+}
+
+func TestResumableUpload(t *testing.T) {
+	srv, _, completed := newUploadServer(t)
+	content := bytes.Repeat([]byte("resumable "), 10000)
+	size := int64(len(content))
+
+	var progress []int64
+	up := &Uploader{
+		ChunkSize: 16 << 10,
+		Progress:  func(offset, _ int64) { progress = append(progress, offset) },
+	}
+	ctx := context.Background()
+	loc, err := up.Create(ctx, srv.URL+"/files/", size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(loc, srv.URL+"/files/") {
+		t.Fatalf("unexpected location %q", loc)
+	}
+
+	// the first attempt fails partway through the third chunk
+	r := &failingReaderAt{r: bytes.NewReader(content), fail: 40 << 10}
+	if err = up.Upload(ctx, loc, r, size); err == nil {
+		t.Fatal("expected the first attempt to fail")
+	}
+	offset, err := up.Offset(ctx, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 32<<10 {
+		t.Fatalf("expected offset %d; actual %d", 32<<10, offset)
+	}
+
+	r.fail = -1
+	if err = up.Upload(ctx, loc, r, size); err != nil {
+		t.Fatal(err)
+	}
+	if last := progress[len(progress)-1]; last != size {
+		t.Errorf("progress ended at %d of %d", last, size)
+	}
+
+	b, err := ioutil.ReadFile(<-completed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Fatal("uploaded file differs")
+	}
+
+	// a completed upload reports all of its bytes, so resuming is a no-op
+	if offset, err = up.Offset(ctx, loc); err != nil || offset != size {
+		t.Errorf("expected offset %d; actual %d %v", size, offset, err)
+	}
+	if err = up.Upload(ctx, loc, r, size); err != nil {
+		t.Error(err)
+	}
+}
+
+// lossyDoer sends requests but loses the response to the final PATCH, as if
+// the connection dropped right after the server handled it.
+type lossyDoer struct {
+	size int64
+}
+
+func (d lossyDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || req.Method != http.MethodPatch {
+		return resp, err
+	}
+	_ = resp.Body.Close()
+	if resp.Header.Get("Upload-Offset") == strconv.FormatInt(d.size, 10) {
+		return nil, errors.New("connection reset")
+	}
+	return resp, nil
+}
+
+func TestResumableLostFinalResponse(t *testing.T) {
+	srv, _, completed := newUploadServer(t)
+	content := bytes.Repeat([]byte("resumable "), 5000)
+	size := int64(len(content))
+
+	ctx := context.Background()
+	up := &Uploader{Client: lossyDoer{size: size}, ChunkSize: 16 << 10}
+	loc, err := up.Create(ctx, srv.URL+"/files/", size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = up.Upload(ctx, loc, bytes.NewReader(content), size); err == nil {
+		t.Fatal("expected the lost response to fail the upload")
+	}
+	<-completed
+
+	// the server has every byte, so resuming finishes right away
+	up.Client = nil
+	if err = up.Upload(ctx, loc, bytes.NewReader(content), size); err != nil {
+		t.Fatal(err)
+	}
+
+	// a retry of the final chunk doesn't corrupt the upload
+	resp := patch(t, loc, int(size), "", "")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != strconv.FormatInt(size, 10) {
+		t.Errorf("unexpected response %s, offset %q", resp.Status, resp.Header.Get("Upload-Offset"))
+	}
+}
+
+func patch(t *testing.T, loc string, offset int, body, checksum string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPatch, loc, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", OffsetOctetType)
+	req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	if checksum != "" {
+		req.Header.Set("Upload-Checksum", checksum)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	return resp
+}
+
+func TestResumablePatchErrors(t *testing.T) {
+	srv, u, _ := newUploadServer(t)
+	loc, err := new(Uploader).Create(context.Background(), srv.URL+"/files/", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("hello"))
+	good := "sha256 " + base64.StdEncoding.EncodeToString(sum[:])
+	testCases := []struct {
+		offset   int
+		body     string
+		checksum string
+		code     int
+		current  string
+	}{
+		{3, "hello", "", http.StatusConflict, "0"},
+		{0, "hello", "sha256 " + base64.StdEncoding.EncodeToString(make([]byte, 32)), StatusChecksumMismatch, ""},
+		{0, "hello", "md5 AAAA", http.StatusBadRequest, ""},
+		{0, "hello world", "", http.StatusRequestEntityTooLarge, ""},
+		{0, "hello", good, http.StatusNoContent, "5"},
+		{0, "hello", good, http.StatusConflict, "5"},
+	}
+	for i, tc := range testCases {
+		resp := patch(t, loc, tc.offset, tc.body, tc.checksum)
+		if resp.StatusCode != tc.code {
+			t.Errorf("%d: expected status %d; actual %d", i, tc.code, resp.StatusCode)
+		}
+		if actual := resp.Header.Get("Upload-Offset"); tc.current != "" && actual != tc.current {
+			t.Errorf("%d: expected offset %s; actual %q", i, tc.current, actual)
+		}
+	}
+
+	// rejected chunks left nothing behind
+	id := loc[strings.LastIndex(loc, "/")+1:]
+	b, err := ioutil.ReadFile(filepath.Join(u.Dir, id+".part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Errorf("unexpected partial content %q", b)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, loc, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status %d; actual %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if _, err = os.Stat(filepath.Join(u.Dir, id+".part")); !os.IsNotExist(err) {
+		t.Errorf("expected the upload to be removed; actual %v", err)
+	}
+}
+
+func TestResumableCreate(t *testing.T) {
+	srv, _, completed := newUploadServer(t)
+	up := new(Uploader)
+
+	if _, err := up.Create(context.Background(), srv.URL+"/files/", 2<<20); err == nil {
+		t.Error("expected an upload beyond MaxSize to be refused")
+	}
+
+	// an empty upload is complete as soon as it's created
+	if _, err := up.Create(context.Background(), srv.URL+"/files/", 0); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(<-completed); err != nil || fi.Size() != 0 {
+		t.Errorf("expected an empty file; actual %v %v", fi, err)
+	}
+
+	resp, err := http.Get(srv.URL + "/files/../etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d; actual %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
@@ -0,0 +1,259 @@
+// Package graceful runs HTTP servers until a signal arrives, then drains
+// them: it reports not ready so load balancers stop sending traffic, lets
+// in-flight requests finish, and closes whatever is left at a deadline.
+package graceful
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long Run waits for requests to finish.
+const DefaultShutdownTimeout = 30 * time.Second
+
+type server struct {
+	*http.Server
+	l net.Listener
+}
+
+// Runner serves a set of HTTP servers and shuts them all down together.
+type Runner struct {
+	// ShutdownTimeout bounds the time to drain connections after DrainDelay;
+	// defaults to DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+	// DrainDelay is the time between reporting not ready and shutting down,
+	// giving load balancers a chance to notice. Defaults to none.
+	DrainDelay time.Duration
+	// Signals that start the shutdown; defaults to SIGINT and SIGTERM.
+	Signals []os.Signal
+	Logger  *log.Logger // defaults to discarding log output
+
+	servers  []server
+	notReady int32 // atomic; 1 once the shutdown starts
+
+	mu       sync.Mutex
+	hijacked map[net.Conn]struct{}
+}
+
+// Add registers srv to be served on l by Run. If l is nil, Run listens on
+// srv.Addr.
+func (r *Runner) Add(srv *http.Server, l net.Listener) {
+	r.servers = append(r.servers, server{Server: srv, l: l})
+}
+
+// Ready reports whether the runner is serving and not shutting down.
+func (r *Runner) Ready() bool {
+	return atomic.LoadInt32(&r.notReady) == 0
+}
+
+// ReadinessHandler answers 200 OK while the runner is ready and 503 Service
+// Unavailable once it starts shutting down.
+func (r *Runner) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if !r.Ready() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
+
+// Run serves all servers until ctx is done, one of the signals arrives, or a
+// server fails. Then it shuts them all down and returns the first serving
+// error, or nil after a clean shutdown. Connections still open when
+// ShutdownTimeout passes, including those hijacked with Hijack, are closed,
+// and Run returns context.DeadlineExceeded.
+func (r *Runner) Run(ctx context.Context) error {
+	logger := r.Logger
+	if logger == nil {
+		logger = log.New(ioutil.Discard, "", 0)
+	}
+
+	for i, s := range r.servers {
+		if s.l != nil {
+			continue
+		}
+		addr := s.Addr
+		if addr == "" {
+			addr = ":http"
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, s := range r.servers[:i] {
+				_ = s.l.Close()
+			}
+			return err
+		}
+		r.servers[i].l = l
+	}
+
+	errs := make(chan error, len(r.servers))
+	for _, s := range r.servers {
+		go func(s server) {
+			err := s.Serve(s.l)
+			if err == http.ErrServerClosed {
+				err = nil
+			}
+			errs <- err
+		}(s)
+	}
+
+	signals := r.Signals
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, signals...)
+	defer signal.Stop(sig)
+
+	var err error
+	running := len(r.servers)
+	select {
+	case <-ctx.Done():
+		logger.Print("shutting down")
+	case s := <-sig:
+		logger.Printf("received %v; shutting down", s)
+	case err = <-errs:
+		running--
+		logger.Printf("server failed: %v; shutting down", err)
+	}
+
+	if sErr := r.shutdown(logger); err == nil {
+		err = sErr
+	}
+	for ; running > 0; running-- {
+		if sErr := <-errs; err == nil {
+			err = sErr
+		}
+	}
+	return err
+}
+
+func (r *Runner) shutdown(logger *log.Logger) error {
+	atomic.StoreInt32(&r.notReady, 1)
+	if r.DrainDelay > 0 {
+		time.Sleep(r.DrainDelay)
+	}
+
+	timeout := r.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, s := range r.servers {
+		wg.Add(1)
+		go func(s server) {
+			defer wg.Done()
+			err := s.Shutdown(ctx)
+			if err != nil {
+				// stragglers at the deadline
+				_ = s.Close()
+			}
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+		}(s)
+	}
+	wg.Wait()
+
+	// Shutdown doesn't wait for hijacked connections, so wait for the
+	// handlers of those hijacked with Hijack to close them until the deadline
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for r.hijackedCount() > 0 && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+	if n := r.closeHijacked(); n > 0 {
+		logger.Printf("closed %d hijacked connections", n)
+		if firstErr == nil {
+			firstErr = ctx.Err()
+		}
+	}
+	return firstErr
+}
+
+// Hijack takes over the connection of w like http.Hijacker, and has the
+// shutdown wait for the connection to close until the deadline, when it
+// closes the connection. Closing the returned connection lets the runner
+// forget it. Connections hijacked directly through http.Hijacker are left to
+// their handlers, as with http.Server.Shutdown. Use the server's
+// RegisterOnShutdown to tell handlers of hijacked connections to wrap up.
+func (r *Runner) Hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	c, rw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	r.track(c)
+	return &trackedConn{Conn: c, r: r}, rw, nil
+}
+
+func (r *Runner) track(c net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.hijacked == nil {
+		r.hijacked = make(map[net.Conn]struct{})
+	}
+	r.hijacked[c] = struct{}{}
+}
+
+func (r *Runner) untrack(c net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.hijacked, c)
+}
+
+func (r *Runner) hijackedCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.hijacked)
+}
+
+func (r *Runner) closeHijacked() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(r.hijacked)
+	for c := range r.hijacked {
+		_ = c.Close()
+		delete(r.hijacked, c)
+	}
+	return n
+}
+
+type trackedConn struct {
+	net.Conn
+	r    *Runner
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.r.untrack(c.Conn) })
+	return c.Conn.Close()
+}
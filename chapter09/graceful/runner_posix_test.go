@@ -0,0 +1,45 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package graceful
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunSignal(t *testing.T) {
+	// keep SIGUSR1 from killing the test binary before Run catches it
+	ignored := make(chan os.Signal, 1)
+	signal.Notify(ignored, syscall.SIGUSR1)
+	defer signal.Stop(ignored)
+
+	r := &Runner{Signals: []os.Signal{syscall.SIGUSR1}}
+	r.Add(&http.Server{Handler: http.NotFoundHandler()}, listen(t))
+
+	done := make(chan error, 1)
+	go func() { done <- r.Run(context.Background()) }()
+
+	// retry until Run has registered for the signal
+	timeout := time.After(5 * time.Second)
+	for {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("Run didn't stop on the signal")
+		}
+	}
+}
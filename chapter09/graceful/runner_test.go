@@ -0,0 +1,227 @@
+package graceful
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestRunDrains(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	})
+
+	r := new(Runner)
+	mux.Handle("/ready", r.ReadinessHandler())
+	l := listen(t)
+	r.Add(&http.Server{Handler: mux}, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/slow")
+		if err != nil {
+			slow <- result{err: err}
+			return
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		slow <- result{string(b), err}
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	r.ReadinessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected ready; actual status %d", w.Code)
+	}
+
+	cancel()
+	for r.Ready() {
+		time.Sleep(time.Millisecond)
+	}
+	w = httptest.NewRecorder()
+	r.ReadinessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected not ready while draining; actual status %d", w.Code)
+	}
+
+	// new connections are refused while the slow request finishes
+	if _, err := net.DialTimeout("tcp", l.Addr().String(), time.Second); err == nil {
+		t.Error("expected new connections to be refused")
+	}
+
+	close(release)
+	if res := <-slow; res.err != nil || res.body != "done" {
+		t.Fatalf("in-flight request failed: %q %v", res.body, res.err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunClosesHijackedAtDeadline(t *testing.T) {
+	r := &Runner{ShutdownTimeout: 100 * time.Millisecond}
+	hijacked := make(chan struct{})
+	l := listen(t)
+	r.Add(&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		conn, _, err := r.Hijack(w)
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+		close(hijacked)
+		// a long-lived connection that outlives the handler
+	})}, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-hijacked
+
+	start := time.Now()
+	cancel()
+	if err = <-done; err != context.DeadlineExceeded {
+		t.Fatalf("expected %v; actual %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("shutdown didn't wait for the deadline: %v", elapsed)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("expected the server to close the connection: %v", err)
+	}
+	if string(b) != "HTTP/1.1 101 Switching Protocols\r\n\r\n" {
+		t.Errorf("unexpected data %q", b)
+	}
+}
+
+func TestRunHijackedClosedByHandler(t *testing.T) {
+	r := &Runner{ShutdownTimeout: 5 * time.Second}
+	l := listen(t)
+	srv := &http.Server{}
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		conn, _, err := r.Hijack(w)
+		if err != nil {
+			return
+		}
+		// the handler wraps up once told the server is going away
+		srv.RegisterOnShutdown(func() { _ = conn.Close() })
+	})
+	r.Add(srv, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	for r.hijackedCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown waited for the deadline: %v", elapsed)
+	}
+}
+
+func TestRunDirectlyHijacked(t *testing.T) {
+	r := &Runner{ShutdownTimeout: 5 * time.Second}
+	closed := make(chan struct{})
+	l := listen(t)
+	r.Add(&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// as reverse proxies and websocket libraries do
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))
+		_ = conn.Close()
+		close(closed)
+	})}, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	resp, err := http.Get("http://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	<-closed
+	if n := r.hijackedCount(); n != 0 {
+		t.Errorf("expected no tracked connections; actual %d", n)
+	}
+
+	start := time.Now()
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown waited for the deadline: %v", elapsed)
+	}
+}
+
+func TestRunServerFailure(t *testing.T) {
+	r := new(Runner)
+	ok, failing := listen(t), listen(t)
+	_ = failing.Close()
+	r.Add(&http.Server{Handler: http.NotFoundHandler()}, ok)
+	r.Add(&http.Server{Handler: http.NotFoundHandler()}, failing)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Run(ctx); err == nil || ctx.Err() != nil {
+		t.Fatalf("expected the failing server's error; actual %v", err)
+	}
+	if r.Ready() {
+		t.Error("expected runner to report not ready")
+	}
+}
@@ -12,6 +12,13 @@ import (
 	"strings"
 )
 
+// Methods routes requests to a handler by request method. A GET handler also
+// serves HEAD requests, unless Methods has a HEAD handler of its own; the
+// server discards the body, after deriving Content-Length and Content-Type
+// from it as for GET. OPTIONS requests and requests with methods
+// Methods doesn't handle get an Allow header listing the supported methods;
+// the latter also get 405 Method Not Allowed. An OPTIONS handler runs after
+// the Allow header is set, so it can answer CORS preflight requests.
 type Methods map[string]http.Handler
 
 func (h Methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -20,7 +27,15 @@ func (h Methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		r.Close()
 	}(r.Body)
 
-	if handler, ok := h[r.Method]; ok {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", h.allowedMethods())
+	}
+
+	handler, ok := h[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		handler, ok = h[http.MethodGet]
+	}
+	if ok {
 		if handler == nil {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		} else {
@@ -28,15 +43,23 @@ func (h Methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	w.Header().Add("Allow", h.allowedMethods())
 	if r.Method != http.MethodOptions {
+		w.Header().Set("Allow", h.allowedMethods())
 		http.Error(w, "Method not allow", http.StatusMethodNotAllowed)
 	}
 }
 
 func (h Methods) allowedMethods() string {
-	a := make([]string, 0, len(h))
+	allowed := map[string]struct{}{http.MethodOptions: {}}
 	for k := range h {
+		allowed[k] = struct{}{}
+	}
+	if _, ok := h[http.MethodGet]; ok {
+		allowed[http.MethodHead] = struct{}{}
+	}
+
+	a := make([]string, 0, len(allowed))
+	for k := range allowed {
 		a = append(a, k)
 	}
 	sort.Strings(a)
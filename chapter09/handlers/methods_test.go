@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMethods(t *testing.T) {
+	h := Methods{
+		http.MethodGet: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handler", "get")
+			_, _ = w.Write([]byte("Hello friend!"))
+		}),
+		http.MethodPost: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	}
+	const allow = "GET, HEAD, OPTIONS, POST"
+
+	testCases := []struct {
+		method string
+		code   int
+		allow  string
+		body   string
+	}{
+		{http.MethodGet, http.StatusOK, "", "Hello friend!"},
+		{http.MethodOptions, http.StatusOK, allow, ""},
+		{http.MethodPut, http.StatusMethodNotAllowed, allow, "Method not allow\n"},
+	}
+
+	for _, c := range testCases {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(c.method, "/", nil))
+
+		if w.Code != c.code {
+			t.Errorf("%s: expected status %d; actual %d", c.method, c.code, w.Code)
+		}
+		if actual := w.Header().Get("Allow"); actual != c.allow {
+			t.Errorf("%s: expected Allow %q; actual %q", c.method, c.allow, actual)
+		}
+		if actual := w.Body.String(); actual != c.body {
+			t.Errorf("%s: expected body %q; actual %q", c.method, c.body, actual)
+		}
+	}
+}
+
+func TestMethodsHead(t *testing.T) {
+	srv := httptest.NewServer(Methods{
+		http.MethodGet: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handler", "get")
+			_, _ = w.Write([]byte("Hello friend!"))
+		}),
+	})
+	defer srv.Close()
+
+	get, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = get.Body.Close()
+	head, err := http.Head(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = head.Body.Close()
+
+	if head.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d; actual %d", http.StatusOK, head.StatusCode)
+	}
+	for _, k := range []string{"Content-Length", "Content-Type", "X-Handler"} {
+		if expected, actual := get.Header.Get(k), head.Header.Get(k); expected == "" || actual != expected {
+			t.Errorf("expected HEAD %s to match GET's %q; actual %q", k, expected, actual)
+		}
+	}
+	if head.ContentLength != int64(len("Hello friend!")) {
+		t.Errorf("expected content length %d; actual %d", len("Hello friend!"), head.ContentLength)
+	}
+}
+
+func TestMethodsOptionsHook(t *testing.T) {
+	h := Methods{
+		http.MethodPut: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		http.MethodOptions: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// a CORS preflight response built from the Allow header
+			w.Header().Set("Access-Control-Allow-Origin", "https://example.com")
+			w.Header().Set("Access-Control-Allow-Methods", w.Header().Get("Allow"))
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	}
+
+	r := httptest.NewRequest(http.MethodOptions, "/", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d; actual %d", http.StatusNoContent, w.Code)
+	}
+	if actual := w.Header().Get("Access-Control-Allow-Methods"); actual != "OPTIONS, PUT" {
+		t.Errorf("expected allowed methods %q; actual %q", "OPTIONS, PUT", actual)
+	}
+
+	// no GET handler, so no HEAD either
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d; actual %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
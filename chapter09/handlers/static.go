@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go-network/chapter09/middleware"
+)
+
+// DefaultCacheControl maps file extensions to Cache-Control values for
+// StaticFiles without its own map. HTML revalidates on every request so new
+// deployments show up at once; assets are cached for a day.
+var DefaultCacheControl = map[string]string{
+	".html": "no-cache",
+	".css":  "public, max-age=86400",
+	".js":   "public, max-age=86400",
+	".svg":  "public, max-age=86400",
+	".png":  "public, max-age=86400",
+	".jpg":  "public, max-age=86400",
+	".ico":  "public, max-age=86400",
+	"":      "public, max-age=3600", // everything else
+}
+
+// encodings lists precompressed variants in order of preference.
+var encodings = []struct {
+	name, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// StaticFiles serves the files below a directory. Unlike http.FileServer,
+// it hides dotfiles, never lists directories, serves a precompressed .br or
+// .gz variant when the client accepts it, and sets ETag and Cache-Control
+// headers. http.ServeContent takes care of range and conditional requests.
+// Symbolic links pointing outside the directory are treated as missing.
+func StaticFiles(dir string, cacheControl map[string]string) http.Handler {
+	if cacheControl == nil {
+		cacheControl = DefaultCacheControl
+	}
+	return middleware.RestrictPrefix(".", &static{dir: dir, cacheControl: cacheControl})
+}
+
+type static struct {
+	dir          string
+	cacheControl map[string]string
+}
+
+func (s *static) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	f, fi, err := s.open(name)
+	if err == nil && fi.IsDir() {
+		_ = f.Close()
+		name = path.Join(name, "index.html")
+		f, fi, err = s.open(name)
+	}
+	if err != nil || !fi.Mode().IsRegular() {
+		if f != nil {
+			_ = f.Close()
+		}
+		http.NotFound(w, r)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	ext := path.Ext(name)
+	h := w.Header()
+	if ctype := mime.TypeByExtension(ext); ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+	if cc, ok := s.cacheControl[ext]; ok {
+		h.Set("Cache-Control", cc)
+	} else if cc, ok = s.cacheControl[""]; ok {
+		h.Set("Cache-Control", cc)
+	}
+
+	suffix := ""
+	if ext != ".br" && ext != ".gz" {
+		h.Add("Vary", "Accept-Encoding")
+		for _, e := range encodings {
+			if !acceptsEncoding(r, e.name) {
+				continue
+			}
+			cf, cfi, err := s.open(name + e.ext)
+			if err != nil {
+				continue
+			}
+			if !cfi.Mode().IsRegular() {
+				_ = cf.Close()
+				continue
+			}
+			_ = f.Close()
+			f, fi, suffix = cf, cfi, "-"+e.name
+			h.Set("Content-Encoding", e.name)
+			break
+		}
+	}
+
+	// the variant's size and time identify its contents well enough
+	h.Set("ETag", fmt.Sprintf(`"%x-%x%s"`, fi.ModTime().UnixNano(), fi.Size(), suffix))
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+}
+
+// open opens the file at the slash-separated name below s.dir, refusing
+// anything that resolves to a path outside of it.
+func (s *static) open(name string) (*os.File, os.FileInfo, error) {
+	root, err := filepath.EvalSymlinks(s.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, nil, err
+	}
+	if p != root && !strings.HasPrefix(p, root+string(filepath.Separator)) {
+		return nil, nil, os.ErrNotExist
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of r allows
+// coding with a non-zero quality.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(v, ",") {
+			fields := strings.Split(strings.TrimSpace(part), ";")
+			if !strings.EqualFold(strings.TrimSpace(fields[0]), coding) {
+				continue
+			}
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					q, err := strconv.ParseFloat(param[2:], 64)
+					return err == nil && q > 0
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func staticDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	root := filepath.Join(dir, "public")
+	files := map[string]string{
+		"public/index.html":         "<h1>home</h1>",
+		"public/app.js":             "console.log('plain')",
+		"public/app.js.gz":          "gzipped",
+		"public/app.js.br":          "brotli",
+		"public/style.css":          "body{}",
+		"public/.secret":            "secret",
+		"public/.git/config":        "secret",
+		"public/docs/readme.txt":    "0123456789",
+		"public/empty/.placeholder": "",
+		"private.txt":               "secret",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Symlink(filepath.Join(dir, "private.txt"), filepath.Join(root, "escape.txt")); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestStaticFiles(t *testing.T) {
+	h := StaticFiles(staticDir(t), nil)
+
+	testCases := []struct {
+		path           string
+		acceptEncoding string
+		code           int
+		body           string
+		encoding       string
+	}{
+		{"/", "", http.StatusOK, "<h1>home</h1>", ""},
+		{"/style.css", "", http.StatusOK, "body{}", ""},
+		{"/app.js", "", http.StatusOK, "console.log('plain')", ""},
+		{"/app.js", "gzip", http.StatusOK, "gzipped", "gzip"},
+		{"/app.js", "gzip, br", http.StatusOK, "brotli", "br"},
+		{"/app.js", "br;q=0, gzip;q=0.5", http.StatusOK, "gzipped", "gzip"},
+		{"/style.css", "gzip", http.StatusOK, "body{}", ""}, // no variant
+		{"/docs/", "", http.StatusNotFound, "", ""},         // no listing
+		{"/empty", "", http.StatusNotFound, "", ""},
+		{"/missing.txt", "", http.StatusNotFound, "", ""},
+		// dotfiles
+		{"/.secret", "", http.StatusNotFound, "", ""},
+		{"/.git/config", "", http.StatusNotFound, "", ""},
+		{"/docs/../.secret", "", http.StatusNotFound, "", ""},
+		// traversal
+		{"/../private.txt", "", http.StatusNotFound, "", ""},
+		{"/docs/../../private.txt", "", http.StatusNotFound, "", ""},
+		{"/%2e%2e/private.txt", "", http.StatusNotFound, "", ""},
+		{"/..%2fprivate.txt", "", http.StatusNotFound, "", ""},
+		{"/..\\private.txt", "", http.StatusNotFound, "", ""},
+		{"/escape.txt", "", http.StatusNotFound, "", ""},
+	}
+	for _, c := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "http://test"+c.path, nil)
+		if c.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", c.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != c.code {
+			t.Errorf("%s: expected status %d; actual %d", c.path, c.code, w.Code)
+			continue
+		}
+		if c.code != http.StatusOK {
+			continue
+		}
+		if body := w.Body.String(); body != c.body {
+			t.Errorf("%s %q: expected body %q; actual %q", c.path, c.acceptEncoding, c.body, body)
+		}
+		if enc := w.Header().Get("Content-Encoding"); enc != c.encoding {
+			t.Errorf("%s %q: expected encoding %q; actual %q", c.path, c.acceptEncoding, c.encoding, enc)
+		}
+	}
+}
+
+func TestStaticFilesHeaders(t *testing.T) {
+	h := StaticFiles(staticDir(t), nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app.js", nil))
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected ETag")
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != DefaultCacheControl[".js"] {
+		t.Errorf("expected Cache-Control %q; actual %q", DefaultCacheControl[".js"], cc)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/javascript; charset=utf-8" &&
+		ct != "application/javascript" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("expected Vary: Accept-Encoding; actual %q", vary)
+	}
+
+	// the compressed variant has its own ETag but the original's type
+	r := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Header().Get("ETag") == etag {
+		t.Error("expected a different ETag for the gzip variant")
+	}
+	if ct := w.Header().Get("Content-Type"); ct == "application/gzip" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected status %d; actual %d", http.StatusNotModified, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/index.html", nil))
+	if cc := w.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("expected Cache-Control no-cache; actual %q", cc)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/app.js", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d; actual %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+func TestStaticFilesRange(t *testing.T) {
+	h := StaticFiles(staticDir(t), nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/docs/readme.txt", nil)
+	r.Header.Set("Range", "bytes=2-5")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected status %d; actual %d", http.StatusPartialContent, w.Code)
+	}
+	if body := w.Body.String(); body != "2345" {
+		t.Errorf("expected %q; actual %q", "2345", body)
+	}
+	if cr := w.Header().Get("Content-Range"); cr != "bytes 2-5/10" {
+		t.Errorf("expected Content-Range %q; actual %q", "bytes 2-5/10", cr)
+	}
+}
@@ -1 +1,110 @@
+// Package http2 configures HTTP/2 servers: over TLS, where clients pick the
+// protocol with ALPN, and over cleartext (h2c) for internal traffic where
+// TLS terminates at a load balancer.
 package http2
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// DefaultMaxConcurrentStreams is the number of streams a client may open on
+// one connection at a time if Options doesn't say otherwise.
+const DefaultMaxConcurrentStreams = 250
+
+// Options tune the servers returned by NewTLSServer and NewH2CServer.
+type Options struct {
+	MaxConcurrentStreams uint32        // defaults to DefaultMaxConcurrentStreams
+	IdleTimeout          time.Duration // defaults to 5 minutes
+	ReadHeaderTimeout    time.Duration // defaults to 1 minute
+
+	// Push maps request paths to the assets pushed along with them, for
+	// example "/" to "/style.css" and "/app.js".
+	Push map[string][]string
+}
+
+func (o Options) http2Server() *http2.Server {
+	s := &http2.Server{
+		MaxConcurrentStreams: o.MaxConcurrentStreams,
+		IdleTimeout:          o.IdleTimeout,
+	}
+	if s.MaxConcurrentStreams == 0 {
+		s.MaxConcurrentStreams = DefaultMaxConcurrentStreams
+	}
+	if s.IdleTimeout == 0 {
+		s.IdleTimeout = 5 * time.Minute
+	}
+	return s
+}
+
+func (o Options) server(addr string, h http.Handler) *http.Server {
+	if len(o.Push) > 0 {
+		h = Push(o.Push, h)
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		IdleTimeout:       o.IdleTimeout,
+		ReadHeaderTimeout: o.ReadHeaderTimeout,
+	}
+	if srv.IdleTimeout == 0 {
+		srv.IdleTimeout = 5 * time.Minute
+	}
+	if srv.ReadHeaderTimeout == 0 {
+		srv.ReadHeaderTimeout = time.Minute
+	}
+	return srv
+}
+
+// NewTLSServer returns a server that negotiates HTTP/2 with clients
+// supporting it and falls back to HTTP/1.1 for the rest. Start it with
+// ListenAndServeTLS or ServeTLS and a certificate such as chapter09's
+// cert.pem and key.pem.
+func NewTLSServer(addr string, h http.Handler, o Options) (*http.Server, error) {
+	srv := o.server(addr, h)
+	srv.TLSConfig = &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	}
+	// adds "h2" to the ALPN protocols and the HTTP/2 connection handler
+	if err := http2.ConfigureServer(srv, o.http2Server()); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// NewH2CServer returns a cleartext server that speaks HTTP/2 to clients that
+// send the HTTP/2 preface right away or upgrade from HTTP/1.1, and HTTP/1.1
+// to everyone else. Start it with ListenAndServe or Serve. Only use it
+// where the network itself is trusted.
+func NewH2CServer(addr string, h http.Handler, o Options) *http.Server {
+	srv := o.server(addr, h)
+	srv.Handler = h2c.NewHandler(srv.Handler, o.http2Server())
+	return srv
+}
+
+// Push pushes the assets listed in pushes for a request's path before
+// handing GET requests to next, so the client has them by the time it parses
+// the page. Clients that disabled push, and HTTP/1 connections, just get
+// the page.
+func Push(pushes map[string][]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if pusher, ok := w.(http.Pusher); ok && r.Method == http.MethodGet {
+			opts := &http.PushOptions{Header: http.Header{}}
+			// pushed responses should match what the client would request
+			if ae := r.Header.Get("Accept-Encoding"); ae != "" {
+				opts.Header.Set("Accept-Encoding", ae)
+			}
+			for _, asset := range pushes[r.URL.Path] {
+				if err := pusher.Push(asset, opts); err != nil {
+					break // http.ErrNotSupported; further pushes fail too
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
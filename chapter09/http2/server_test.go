@@ -0,0 +1,229 @@
+package http2
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"go-network/internal/testcert"
+)
+
+func proto(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.WriteString(w, r.Proto)
+}
+
+// serverSettings performs the client side of the HTTP/2 handshake on conn
+// and returns the settings the server announces.
+func serverSettings(t *testing.T, conn net.Conn) map[http2.SettingID]uint32 {
+	if _, err := io.WriteString(conn, http2.ClientPreface); err != nil {
+		t.Fatal(err)
+	}
+	fr := http2.NewFramer(conn, conn)
+	if err := fr.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	f, err := fr.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, ok := f.(*http2.SettingsFrame)
+	if !ok {
+		t.Fatalf("expected SETTINGS frame; actual %v", f)
+	}
+	settings := make(map[http2.SettingID]uint32)
+	_ = sf.ForeachSetting(func(s http2.Setting) error {
+		settings[s.ID] = s.Val
+		return nil
+	})
+	return settings
+}
+
+func TestTLSServer(t *testing.T) {
+	// chapter09's cert.pem has expired
+	cert := testcert.New(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	testcert.Write(t, cert, certFile, keyFile)
+	pool := testcert.Pool(cert)
+
+	srv, err := NewTLSServer("127.0.0.1:", http.HandlerFunc(proto),
+		Options{MaxConcurrentStreams: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.ServeTLS(l, certFile, keyFile) }()
+	defer srv.Close()
+	url := "https://" + l.Addr().String() + "/"
+
+	testCases := []struct {
+		nextProtos []string
+		proto      string
+		alpn       string
+	}{
+		{[]string{"h2", "http/1.1"}, "HTTP/2.0", "h2"},
+		{[]string{"http/1.1"}, "HTTP/1.1", "http/1.1"},
+	}
+	for _, c := range testCases {
+		tr := &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: pool, NextProtos: c.nextProtos},
+			ForceAttemptHTTP2: true,
+		}
+		if c.nextProtos[0] != "h2" {
+			// a non-nil, empty map disables HTTP/2 in the transport
+			tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+		resp, err := (&http.Client{Transport: tr}).Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		tr.CloseIdleConnections()
+
+		if resp.Proto != c.proto || string(b) != c.proto {
+			t.Errorf("expected %s; actual %s served as %s", c.proto, resp.Proto, b)
+		}
+		if alpn := resp.TLS.NegotiatedProtocol; alpn != c.alpn {
+			t.Errorf("expected ALPN %q; actual %q", c.alpn, alpn)
+		}
+	}
+
+	conn, err := tls.Dial("tcp", l.Addr().String(),
+		&tls.Config{RootCAs: pool, NextProtos: []string{"h2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if v := serverSettings(t, conn)[http2.SettingMaxConcurrentStreams]; v != 42 {
+		t.Errorf("expected max concurrent streams %d; actual %d", 42, v)
+	}
+}
+
+func TestH2CServer(t *testing.T) {
+	srv := NewH2CServer("127.0.0.1:", http.HandlerFunc(proto), Options{})
+	l, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+	url := "http://" + l.Addr().String() + "/"
+
+	// prior knowledge: the client starts with the HTTP/2 preface
+	h2 := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+	defer h2.CloseIdleConnections()
+	h1 := &http.Transport{}
+	defer h1.CloseIdleConnections()
+
+	for expected, rt := range map[string]http.RoundTripper{"HTTP/2.0": h2, "HTTP/1.1": h1} {
+		resp, err := (&http.Client{Transport: rt}).Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.Proto != expected || string(b) != expected {
+			t.Errorf("expected %s; actual %s served as %s", expected, resp.Proto, b)
+		}
+	}
+
+	// upgrade from HTTP/1.1
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n"+
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: \r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(status, "101") {
+		t.Errorf("expected 101 Switching Protocols; actual %q", status)
+	}
+
+	conn2, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	settings := serverSettings(t, conn2)
+	if v := settings[http2.SettingMaxConcurrentStreams]; v != DefaultMaxConcurrentStreams {
+		t.Errorf("expected max concurrent streams %d; actual %d", DefaultMaxConcurrentStreams, v)
+	}
+}
+
+type pushRecorder struct {
+	*httptest.ResponseRecorder
+	pushed []string
+	header []string
+}
+
+func (p *pushRecorder) Push(target string, opts *http.PushOptions) error {
+	p.pushed = append(p.pushed, target)
+	p.header = append(p.header, opts.Header.Get("Accept-Encoding"))
+	return nil
+}
+
+func TestPush(t *testing.T) {
+	h := Push(map[string][]string{
+		"/": {"/style.css", "/app.js"},
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	testCases := []struct {
+		method, path string
+		pushed       string
+	}{
+		{http.MethodGet, "/", "/style.css,/app.js"},
+		{http.MethodGet, "/other", ""},
+		{http.MethodPost, "/", ""},
+	}
+	for _, c := range testCases {
+		w := &pushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		r := httptest.NewRequest(c.method, c.path, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		h.ServeHTTP(w, r)
+
+		if actual := strings.Join(w.pushed, ","); actual != c.pushed {
+			t.Errorf("%s %s: expected pushes %q; actual %q", c.method, c.path, c.pushed, actual)
+		}
+		for _, ae := range w.header {
+			if ae != "gzip" {
+				t.Errorf("expected pushed requests to accept gzip; actual %q", ae)
+			}
+		}
+	}
+
+	// without push support the page is still served
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d; actual %d", http.StatusOK, w.Code)
+	}
+}
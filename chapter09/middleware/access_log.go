@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// AccessLog logs a line per request to logger once next returns: client
+// address, method, URI, protocol, status code, response size, duration and,
+// if RequestID ran first, the request ID.
+func AccessLog(logger *log.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &responseWriter{ResponseWriter: w}
+			start := time.Now()
+			next.ServeHTTP(rw, r)
+
+			status := rw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			id := RequestIDFromContext(r.Context())
+			if id == "" {
+				id = "-"
+			}
+			logger.Printf("%s %q %d %d %v %s", host,
+				r.Method+" "+r.RequestURI+" "+r.Proto, status, rw.length,
+				time.Since(start), id)
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import "net/http"
+
+// Middleware wraps a handler with additional behavior.
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes middleware into one. The first middleware is the outermost,
+// so it sees the request first and the response last:
+//
+//	Chain(RequestID, AccessLog(logger))(handler)
+//
+// logs requests that already carry an ID.
+func Chain(mw ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+		return next
+	}
+}
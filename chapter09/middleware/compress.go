@@ -0,0 +1,312 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Encoder produces one content coding, such as gzip or br.
+type Encoder struct {
+	Name string // the Content-Encoding token
+	New  func(w io.Writer) io.WriteCloser
+}
+
+// GzipEncoder compresses with compress/gzip at the default level.
+var GzipEncoder = Encoder{
+	Name: "gzip",
+	New:  func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+}
+
+// DefaultCompressibleTypes lists the media types Compressor compresses if
+// ContentTypes is empty. Entries ending in "/" match a whole type.
+var DefaultCompressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// DefaultMinCompressSize is the smallest body Compressor compresses if
+// MinSize is zero. Below it, the coding's header and trailer outweigh the
+// savings.
+const DefaultMinCompressSize = 1024
+
+// Compressor compresses response bodies with the best coding a client
+// accepts. Its Handler method is a Middleware.
+type Compressor struct {
+	// MinSize is the smallest body worth compressing; defaults to
+	// DefaultMinCompressSize.
+	MinSize int
+	// ContentTypes allowed for compression; defaults to
+	// DefaultCompressibleTypes.
+	ContentTypes []string
+	// Encoders in order of preference, used when the client accepts several
+	// codings equally; defaults to GzipEncoder alone.
+	Encoders []Encoder
+}
+
+// Register adds an encoder, for example a brotli or zstd implementation,
+// and prefers it to the encoders added before.
+func (c *Compressor) Register(e Encoder) {
+	if len(c.Encoders) == 0 {
+		c.Encoders = []Encoder{GzipEncoder}
+	}
+	c.Encoders = append([]Encoder{e}, c.Encoders...)
+}
+
+// Handler compresses the responses of next. The decision waits until the
+// body reaches MinSize, next flushes, or next returns, so small responses go
+// out as they are. Responses that already have a Content-Encoding, partial
+// content responses and responses of other types pass through unchanged.
+// HEAD responses get the headers the GET response would, as far as next sets
+// the same Content-Type and Content-Length, but no body.
+func (c *Compressor) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc, ok := c.negotiate(r)
+		cw := &compressWriter{
+			ResponseWriter: w,
+			c:              c,
+			enc:            enc,
+			identity:       !ok || r.Header.Get("Range") != "",
+			head:           r.Method == http.MethodHead,
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+func (c *Compressor) minSize() int {
+	if c.MinSize <= 0 {
+		return DefaultMinCompressSize
+	}
+	return c.MinSize
+}
+
+func (c *Compressor) encoders() []Encoder {
+	if len(c.Encoders) == 0 {
+		return []Encoder{GzipEncoder}
+	}
+	return c.Encoders
+}
+
+// negotiate picks the encoder with the highest quality in the request's
+// Accept-Encoding header, breaking ties by preference.
+func (c *Compressor) negotiate(r *http.Request) (Encoder, bool) {
+	accepted := make(map[string]float64)
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(v, ",") {
+			fields := strings.Split(part, ";")
+			name := strings.ToLower(strings.TrimSpace(fields[0]))
+			if name == "" {
+				continue
+			}
+			q := 1.0
+			for _, p := range fields[1:] {
+				p = strings.TrimSpace(p)
+				if strings.HasPrefix(p, "q=") {
+					if f, err := strconv.ParseFloat(p[2:], 64); err == nil {
+						q = f
+					}
+				}
+			}
+			accepted[name] = q
+		}
+	}
+
+	var (
+		best  Encoder
+		bestQ float64
+	)
+	for _, e := range c.encoders() {
+		q, ok := accepted[e.Name]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+func (c *Compressor) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	types := c.ContentTypes
+	if len(types) == 0 {
+		types = DefaultCompressibleTypes
+	}
+	for _, t := range types {
+		if mediaType == t || strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter buffers the start of the body until it knows whether to
+// compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	c        *Compressor
+	enc      Encoder
+	identity bool // never compress, so don't buffer either
+	head     bool // the body is never sent
+
+	status  int
+	buf     []byte
+	decided bool
+	w       io.WriteCloser // nil unless compressing
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 {
+		if w.decided {
+			w.ResponseWriter.WriteHeader(status)
+		}
+		return
+	}
+	w.status = status
+	if w.identity || status < http.StatusOK || status == http.StatusNoContent ||
+		status == http.StatusNotModified || status == http.StatusPartialContent {
+		// no body to compress, or a range of one
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided && w.identity {
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+	}
+	if w.decided {
+		if w.w != nil {
+			return w.w.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.c.minSize() {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends the header and the buffered body, compressed if sizeOK, the
+// content type qualifies and nothing encoded the body yet.
+func (w *compressWriter) decide(sizeOK bool) error {
+	w.decided = true
+	h := w.Header()
+	addVary(h, "Accept-Encoding")
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if sizeOK && !w.identity && h.Get("Content-Encoding") == "" && w.c.compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", w.enc.Name)
+		h.Del("Content-Length")
+		// the compressed body isn't byte-for-byte what a strong ETag names
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+		if w.head {
+			w.w = w.enc.New(ioutil.Discard)
+		} else {
+			w.w = w.enc.New(w.ResponseWriter)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.w != nil {
+		_, err = w.w.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what the handler wrote so far, compressing it if the content
+// type qualifies no matter its size, since more is likely to follow.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		_ = w.decide(true)
+	}
+	if f, ok := w.w.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets handlers take over the connection through the wrapper. What
+// the handler wrote before goes out uncompressed.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	if !w.decided && (w.status != 0 || len(w.buf) > 0) {
+		if err := w.decide(false); err != nil {
+			return nil, nil, err
+		}
+	}
+	c, rw, err := h.Hijack()
+	if err == nil {
+		w.decided = true
+	}
+	return c, rw, err
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		// next may have declared a length without writing the body, as
+		// http.ServeContent does for HEAD requests
+		n, err := strconv.Atoi(w.Header().Get("Content-Length"))
+		_ = w.decide(err == nil && n >= w.c.minSize())
+	}
+	if w.w != nil {
+		_ = w.w.Close()
+	}
+}
+
+// addVary adds token to the Vary header unless it's listed already, perhaps
+// by next.
+func addVary(h http.Header, token string) {
+	for _, v := range h.Values("Vary") {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t == "*" || strings.EqualFold(t, token) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", token)
+}
@@ -0,0 +1,286 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lengthWriter counts what passes through it, like chapter13's
+// wideResponseWriter.
+type lengthWriter struct {
+	http.ResponseWriter
+	length int
+}
+
+func (w *lengthWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.length += n
+	return n, err
+}
+
+func countLength(counted *int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lw := &lengthWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r)
+		*counted = lw.length
+	})
+}
+
+func gunzip(t *testing.T, b []byte) string {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestCompressor(t *testing.T) {
+	large := strings.Repeat("hello friend ", 200)
+	c := &Compressor{}
+
+	testCases := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		encoding       string
+	}{
+		{"gzip", "gzip, deflate", "text/plain; charset=utf-8", large, "gzip"},
+		{"sniffed type", "gzip", "", "<html><body>" + large, "gzip"},
+		{"not accepted", "", "text/plain", large, ""},
+		{"refused", "gzip;q=0", "text/plain", large, ""},
+		{"wildcard", "*", "text/plain", large, "gzip"},
+		{"too small", "gzip", "text/plain", "hello", ""},
+		{"not allowed type", "gzip", "image/png", large, ""},
+	}
+	for _, c2 := range testCases {
+		var inner, outer int
+		h := countLength(&outer, c.Handler(countLength(&inner,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if c2.contentType != "" {
+					w.Header().Set("Content-Type", c2.contentType)
+				}
+				w.Header().Set("ETag", `"v1"`)
+				// several small writes
+				for i := 0; i < len(c2.body); i += 100 {
+					end := i + 100
+					if end > len(c2.body) {
+						end = len(c2.body)
+					}
+					_, _ = io.WriteString(w, c2.body[i:end])
+				}
+			}))))
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c2.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", c2.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if enc := w.Header().Get("Content-Encoding"); enc != c2.encoding {
+			t.Errorf("%s: expected encoding %q; actual %q", c2.name, c2.encoding, enc)
+		}
+		if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("%s: expected Vary: Accept-Encoding; actual %q", c2.name, vary)
+		}
+
+		body := w.Body.String()
+		if c2.encoding == "gzip" {
+			body = gunzip(t, w.Body.Bytes())
+			if etag := w.Header().Get("ETag"); etag != `W/"v1"` {
+				t.Errorf("%s: expected weak ETag; actual %q", c2.name, etag)
+			}
+		}
+		if body != c2.body {
+			t.Errorf("%s: body mismatch", c2.name)
+		}
+		// handlers see what they wrote, wrappers outside see the wire bytes
+		if inner != len(c2.body) {
+			t.Errorf("%s: expected inner length %d; actual %d", c2.name, len(c2.body), inner)
+		}
+		if outer != w.Body.Len() {
+			t.Errorf("%s: expected outer length %d; actual %d", c2.name, w.Body.Len(), outer)
+		}
+	}
+}
+
+func TestCompressorRegistry(t *testing.T) {
+	c := new(Compressor)
+	c.Register(Encoder{Name: "fake", New: func(w io.Writer) io.WriteCloser {
+		return nopCloser{w}
+	}})
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, strings.Repeat("x", 2048))
+	}))
+
+	testCases := []struct {
+		acceptEncoding, encoding string
+	}{
+		{"gzip, fake", "fake"},       // ties go to the later registration
+		{"gzip, fake;q=0.5", "gzip"}, // the client's preference wins
+		{"gzip;q=0.1, fake;q=0", "gzip"},
+	}
+	for _, c := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", c.acceptEncoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if enc := w.Header().Get("Content-Encoding"); enc != c.encoding {
+			t.Errorf("%q: expected %q; actual %q", c.acceptEncoding, c.encoding, enc)
+		}
+	}
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestCompressorFlush(t *testing.T) {
+	srv := httptest.NewServer((&Compressor{}).Handler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = io.WriteString(w, "first")
+			f, ok := w.(http.Flusher)
+			if !ok {
+				t.Error("compressor hides http.Flusher")
+				return
+			}
+			f.Flush()
+			_, _ = io.WriteString(w, " second")
+		})))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// setting the header ourselves keeps the transport from decompressing
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a flushed response to be compressed")
+	}
+	if body := gunzip(t, b); body != "first second" {
+		t.Errorf("expected %q; actual %q", "first second", body)
+	}
+}
+
+func TestCompressorPassThrough(t *testing.T) {
+	c := &Compressor{MinSize: 1}
+	testCases := []struct {
+		name    string
+		handler http.HandlerFunc
+		code    int
+	}{
+		{"already encoded", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+			_, _ = io.WriteString(w, "precompressed")
+		}, http.StatusOK},
+		{"not modified", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotModified)
+		}, http.StatusNotModified},
+		{"no body", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}, http.StatusCreated},
+	}
+	for _, tc := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		c.Handler(tc.handler).ServeHTTP(w, r)
+
+		if w.Code != tc.code {
+			t.Errorf("%s: expected status %d; actual %d", tc.name, tc.code, w.Code)
+		}
+		if enc := w.Header().Get("Content-Encoding"); enc == "gzip" {
+			t.Errorf("%s: unexpectedly compressed", tc.name)
+		}
+	}
+}
+
+func TestCompressorHijack(t *testing.T) {
+	// http.Get asks for gzip
+	if actual := hijack(t, (&Compressor{MinSize: 1}).Handler); actual != "hijacked" {
+		t.Fatalf("expected %q; actual %q", "hijacked", actual)
+	}
+}
+
+func TestCompressorHead(t *testing.T) {
+	body := strings.Repeat("compress me ", 200)
+	handlers := map[string]http.HandlerFunc{
+		"ServeContent": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+		},
+		"body on HEAD": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = io.WriteString(w, body)
+		},
+	}
+	for name, h := range handlers {
+		c := new(Compressor)
+		resp := make(map[string]*httptest.ResponseRecorder)
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			r := httptest.NewRequest(method, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			c.Handler(h).ServeHTTP(w, r)
+			resp[method] = w
+		}
+
+		get, head := resp[http.MethodGet].Header(), resp[http.MethodHead].Header()
+		for _, k := range []string{"Content-Encoding", "Vary", "Content-Length"} {
+			if g, h := strings.Join(get.Values(k), ", "), strings.Join(head.Values(k), ", "); g != h {
+				t.Errorf("%s: expected HEAD %s %q like GET's; actual %q", name, k, g, h)
+			}
+		}
+		if get.Get("Content-Encoding") != "gzip" {
+			t.Errorf("%s: expected a compressed GET response", name)
+		}
+		if n := resp[http.MethodHead].Body.Len(); n != 0 {
+			t.Errorf("%s: expected no HEAD body; actual %d bytes", name, n)
+		}
+	}
+}
+
+func TestCompressorVary(t *testing.T) {
+	c := &Compressor{MinSize: 1}
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// as handlers.StaticFiles does
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, "varies")
+	}))
+	for _, accept := range []string{"gzip", "identity"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if v := w.Header().Values("Vary"); len(v) != 1 || v[0] != "Accept-Encoding" {
+			t.Errorf("%s: expected one Vary: Accept-Encoding; actual %q", accept, v)
+		}
+	}
+}
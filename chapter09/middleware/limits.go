@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Timeout gives each request a context deadline d from now. Unlike
+// http.TimeoutHandler, it doesn't buffer the response or run next in another
+// goroutine: next must watch r.Context() and return once it's done. If next
+// returns after the deadline without having written a response, Timeout
+// answers 503 Service Unavailable.
+func Timeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			rw := &responseWriter{ResponseWriter: w}
+			next.ServeHTTP(rw, r.WithContext(ctx))
+
+			if rw.status == 0 && ctx.Err() == context.DeadlineExceeded {
+				http.Error(w, "Request timed out", http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
+
+// MaxBytes limits request bodies to n bytes with http.MaxBytesReader. Bodies
+// declaring a larger Content-Length are refused before next runs. Once next
+// reads past the limit of a body without one, the response becomes 413
+// Request Entity Too Large no matter what status next writes, and whatever
+// body next writes is discarded.
+func MaxBytes(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				tooLarge(w)
+				return
+			}
+
+			body := &countingBody{ReadCloser: r.Body}
+			lw := &limitWriter{ResponseWriter: w, body: body, limit: n}
+			r.Body = http.MaxBytesReader(lw, body, n)
+			next.ServeHTTP(lw, r)
+
+			if !lw.wroteHeader && body.n > n {
+				lw.WriteHeader(http.StatusRequestEntityTooLarge)
+			}
+		})
+	}
+}
+
+func tooLarge(w http.ResponseWriter) {
+	// the rest of the body isn't worth reading
+	w.Header().Set("Connection", "close")
+	http.Error(w, "Request entity too large", http.StatusRequestEntityTooLarge)
+}
+
+// countingBody counts the bytes http.MaxBytesReader reads from the request
+// body. It reads at most one byte past the limit, which is how MaxBytes
+// tells that the limit was exceeded.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// limitWriter replaces the response with a 413 once the request body has
+// exceeded the limit.
+type limitWriter struct {
+	http.ResponseWriter
+	body        *countingBody
+	limit       int64
+	wroteHeader bool
+	discard     bool
+}
+
+func (w *limitWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.body.n > w.limit {
+		w.discard = true
+		tooLarge(w.ResponseWriter)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discard {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush lets streaming handlers flush through the wrapper.
+func (w *limitWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets handlers take over the connection through the wrapper. MaxBytes
+// writes nothing to a hijacked connection.
+func (w *limitWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	c, rw, err := h.Hijack()
+	if err == nil {
+		w.wroteHeader = true
+	}
+	return c, rw, err
+}
+
+func (w *limitWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
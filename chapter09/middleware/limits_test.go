@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handler http.HandlerFunc
+		code    int
+	}{
+		{"gives up", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}, http.StatusServiceUnavailable},
+		{"answers in time", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, http.StatusNoContent},
+		{"answers the timeout itself", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}, http.StatusGatewayTimeout},
+	}
+
+	for _, c := range testCases {
+		w := httptest.NewRecorder()
+		start := time.Now()
+		Timeout(50*time.Millisecond)(c.handler).ServeHTTP(w,
+			httptest.NewRequest(http.MethodGet, "/", nil))
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: took %v", c.name, elapsed)
+		}
+		if w.Code != c.code {
+			t.Errorf("%s: expected status %d; actual %d", c.name, c.code, w.Code)
+		}
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(b)
+	})
+	h := MaxBytes(8)(echo)
+
+	testCases := []struct {
+		body          string
+		contentLength int64 // -1 for an unknown length
+		code          int
+	}{
+		{"12345678", 8, http.StatusOK},
+		{"123456789", 9, http.StatusRequestEntityTooLarge},
+		{"12345678", -1, http.StatusOK},
+		{"123456789", -1, http.StatusRequestEntityTooLarge},
+	}
+	for i, c := range testCases {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(c.body))
+		r.ContentLength = c.contentLength
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != c.code {
+			t.Errorf("%d: expected status %d; actual %d", i, c.code, w.Code)
+		}
+		if c.code == http.StatusOK && w.Body.String() != c.body {
+			t.Errorf("%d: expected body %q; actual %q", i, c.body, w.Body)
+		}
+		if c.code != http.StatusOK && strings.Contains(w.Body.String(), "Internal") {
+			t.Errorf("%d: handler's error leaked into the response: %q", i, w.Body)
+		}
+	}
+}
+
+func TestLimitsHijack(t *testing.T) {
+	if actual := hijack(t, Chain(Timeout(time.Second), MaxBytes(8))); actual != "hijacked" {
+		t.Fatalf("expected %q; actual %q", "hijacked", actual)
+	}
+}
+
+func TestMaxBytesFlush(t *testing.T) {
+	h := MaxBytes(8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Error("MaxBytes hides http.Flusher")
+			return
+		}
+		_, _ = w.Write([]byte("partial"))
+		f.Flush()
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !w.Flushed {
+		t.Error("expected the response to be flushed")
+	}
+}
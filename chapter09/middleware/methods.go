@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DenyMethods answers requests using any of methods with 405 Method Not
+// Allowed without calling the next handler. Blocking TRACE keeps handlers
+// from reflecting cookies and credentials back to scripts.
+func DenyMethods(methods ...string) Middleware {
+	deny := make(map[string]struct{}, len(methods))
+	for _, m := range methods {
+		deny[strings.ToUpper(m)] = struct{}{}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := deny[r.Method]; ok {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
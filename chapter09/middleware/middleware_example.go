@@ -13,6 +13,7 @@ func MiddleWare(next http.Handler) http.Handler {
 			//that may not be proper, and the middleware should block the next handler
 			//and respond to the client itself
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
 		}
 
 		w.Header().Set("X-Content-Type-Options", "nosniff")
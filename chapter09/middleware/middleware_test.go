@@ -0,0 +1,228 @@
+package middleware
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := Chain(tag("first"), tag("second"), tag("third"))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			order = append(order, "handler")
+		}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if actual := strings.Join(order, ","); actual != "first,second,third,handler" {
+		t.Fatalf("unexpected order %s", actual)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	testCases := []struct {
+		incoming string
+		keep     bool
+	}{
+		{"", false},
+		{"abc-123", true},
+		{"has space", false},
+		{strings.Repeat("x", 65), false},
+	}
+	for _, c := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c.incoming != "" {
+			r.Header.Set(RequestIDHeader, c.incoming)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		id := w.Header().Get(RequestIDHeader)
+		if id == "" || id != seen {
+			t.Errorf("%q: header %q doesn't match context %q", c.incoming, id, seen)
+		}
+		if keep := id == c.incoming; keep != c.keep {
+			t.Errorf("%q: expected keep %t; actual ID %q", c.incoming, c.keep, id)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	logs := new(bytes.Buffer)
+	h := Recover(log.New(logs, "", 0))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d; actual %d", http.StatusInternalServerError, w.Code)
+	}
+	if !strings.Contains(logs.String(), "boom") {
+		t.Errorf("expected panic to be logged; actual %q", logs)
+	}
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("expected %v to pass through; actual %v", http.ErrAbortHandler, v)
+		}
+	}()
+	Recover(log.New(ioutil.Discard, "", 0))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestDenyMethods(t *testing.T) {
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	for _, h := range []http.Handler{DenyMethods(http.MethodTrace)(next), MiddleWare(next)} {
+		called = false
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodTrace, "/", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected status %d; actual %d", http.StatusMethodNotAllowed, w.Code)
+		}
+		if called {
+			t.Error("next handler ran for a denied method")
+		}
+	}
+
+	called = false
+	DenyMethods("trace")(next).ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodGet, "/", nil))
+	if !called {
+		t.Error("next handler didn't run for an allowed method")
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParseCIDRs("10.0.0.0/8", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var remote string
+	h := RealIP(trusted...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote = r.RemoteAddr
+	}))
+
+	testCases := []struct {
+		remoteAddr string
+		xff        []string
+		expected   string
+	}{
+		// untrusted peers can't spoof the header
+		{"203.0.113.9:1234", []string{"198.51.100.1"}, "203.0.113.9:1234"},
+		{"10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1:1234"},
+		// the client prepended a fake address; proxies appended the rest
+		{"10.0.0.1:1234", []string{"1.1.1.1, 198.51.100.1, 10.0.0.2"}, "198.51.100.1:1234"},
+		{"192.0.2.1:1234", []string{"198.51.100.1", "10.0.0.3"}, "198.51.100.1:1234"},
+		{"10.0.0.1:1234", nil, "10.0.0.1:1234"},
+		{"10.0.0.1:1234", []string{"garbage"}, "10.0.0.1:1234"},
+		{"10.0.0.1:1234", []string{"2001:db8::1"}, "[2001:db8::1]:1234"},
+	}
+	for i, c := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = c.remoteAddr
+		for _, v := range c.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if remote != c.expected {
+			t.Errorf("%d: expected %s; actual %s", i, c.expected, remote)
+		}
+	}
+
+	if _, err = ParseCIDRs("not an address"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	logs := new(bytes.Buffer)
+	h := Chain(RequestID, AccessLog(log.New(logs, "", 0)))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte("hello"))
+		}))
+
+	r := httptest.NewRequest(http.MethodPost, "/chores", nil)
+	r.Header.Set(RequestIDHeader, "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	line := logs.String()
+	for _, s := range []string{"192.0.2.1", `"POST /chores HTTP/1.1"`, " 201 5 ", "req-1"} {
+		if !strings.Contains(line, s) {
+			t.Errorf("expected %q in %q", s, line)
+		}
+	}
+}
+
+// hijack serves a handler that hijacks the connection through mw and
+// returns what the client reads once mw returns.
+func hijack(t *testing.T, mw Middleware) string {
+	t.Helper()
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("middleware hides http.Hijacker")
+			http.Error(w, "no hijacking", http.StatusInternalServerError)
+			return
+		}
+		conn, _, err := hj.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nhijacked"))
+	}))
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	return string(b)
+}
+
+func TestHijack(t *testing.T) {
+	out := new(bytes.Buffer)
+	logger := log.New(out, "", 0)
+	if actual := hijack(t, Chain(AccessLog(logger), Recover(logger))); actual != "hijacked" {
+		t.Fatalf("expected %q; actual %q", "hijacked", actual)
+	}
+	if !strings.Contains(out.String(), " 101 ") {
+		t.Errorf("expected the access log to record a 101 status: %q", out.String())
+	}
+}
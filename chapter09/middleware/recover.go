@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover turns a panic in next into a 500 response and logs it with its
+// stack trace, instead of letting net/http drop the connection. Panics with
+// http.ErrAbortHandler pass through, since they ask for exactly that.
+func Recover(logger *log.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &responseWriter{ResponseWriter: w}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				logger.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL, v, debug.Stack())
+				if rw.status == 0 {
+					http.Error(rw, "Internal server error", http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
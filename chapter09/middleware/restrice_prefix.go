@@ -6,10 +6,12 @@ import (
 	"strings"
 )
 
+// RestrictPrefix answers 404 Not Found for paths with any segment beginning
+// with prefix. With prefix ".", it hides dotfiles and dot directories.
 func RestrictPrefix(prefix string, next http.Handler) http.Handler {
 	return http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		for _, p := range strings.Split(path.Clean(r.URL.Path), "/") {
-			if strings.HasSuffix(p, prefix) {
+			if strings.HasPrefix(p, prefix) {
 				http.Error(w, "Not Found", http.StatusNotFound)
 				return
 			}
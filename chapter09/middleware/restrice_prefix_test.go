@@ -27,3 +27,24 @@ func TestRestrictPrefix(t *testing.T) {
 		}
 	}
 }
+
+func TestRestrictPrefixMatchesSegmentStart(t *testing.T) {
+	handler := RestrictPrefix(".", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	testCase := []struct {
+		path string
+		code int
+	}{
+		{"http://test/file.", http.StatusOK},
+		{"http://test/dir./file", http.StatusOK},
+		{"http://test/.file", http.StatusNotFound},
+		{"http://test/a/.dir/file", http.StatusNotFound},
+	}
+
+	for i, c := range testCase {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if actual := w.Result().StatusCode; c.code != actual {
+			t.Errorf("%d: expected %d; actual %d", i, c.code, actual)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// Package router matches request paths against patterns with named
+// parameters and wildcards, which http.ServeMux can't express.
+//
+// A pattern is a slash-separated list of segments. A segment is either a
+// literal, a parameter such as {id} that matches any single segment, or, as
+// the last segment only, a wildcard such as {path...} that matches the rest
+// of the path, possibly nothing. Trailing slashes are ignored, so /chores/
+// and /chores match the same routes, and neither redirects.
+//
+// When several patterns match a path, the one with a literal in the leftmost
+// position where they differ wins, then the one with a parameter. Given
+// /chores/new, /chores/{id} and /chores/{rest...}, the path /chores/new
+// matches the first, /chores/42 the second and /chores/42/done the third.
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"go-network/chapter09/handlers"
+	"go-network/chapter09/middleware"
+)
+
+// Middleware wraps a handler with behavior shared by a group of routes.
+type Middleware = middleware.Middleware
+
+type kind int
+
+const (
+	literal kind = iota
+	param
+	wildcard
+)
+
+type segment struct {
+	kind  kind
+	value string // the literal, or the parameter's name
+}
+
+type route struct {
+	pattern  string
+	segments []segment
+	handler  http.Handler
+	methods  handlers.Methods // non-nil if the route was added by Method
+	// middleware of the router that added the route, which wraps the
+	// responses methods generates itself
+	middleware Middleware
+}
+
+// less reports whether r takes precedence over o.
+func (r *route) less(o *route) bool {
+	for i := 0; i < len(r.segments) && i < len(o.segments); i++ {
+		if r.segments[i].kind != o.segments[i].kind {
+			return r.segments[i].kind < o.segments[i].kind
+		}
+	}
+	// Without wildcards, patterns of different lengths never match the same
+	// path. With one, the shorter pattern is the more specific.
+	return len(r.segments) < len(o.segments)
+}
+
+// shape returns a key that's equal for patterns matching the same paths.
+func (r *route) shape() string {
+	var b strings.Builder
+	for _, s := range r.segments {
+		switch s.kind {
+		case literal:
+			b.WriteString("/" + s.value)
+		case param:
+			b.WriteString("/{}")
+		case wildcard:
+			b.WriteString("/{...}")
+		}
+	}
+	return b.String()
+}
+
+// match returns the parameters r extracts from segs, or false if r doesn't
+// match them.
+func (r *route) match(segs []string) (map[string]string, bool) {
+	var params map[string]string
+	set := func(k, v string) {
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[k] = v
+	}
+
+	for i, s := range r.segments {
+		if s.kind == wildcard {
+			set(s.value, strings.Join(segs[i:], "/"))
+			return params, true
+		}
+		if i >= len(segs) {
+			return nil, false
+		}
+		switch s.kind {
+		case literal:
+			if segs[i] != s.value {
+				return nil, false
+			}
+		case param:
+			set(s.value, segs[i])
+		}
+	}
+	if len(segs) != len(r.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+func parse(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("pattern %q doesn't begin with /", pattern)
+	}
+
+	var segs []segment
+	names := make(map[string]bool)
+	parts := split(pattern)
+	for i, p := range parts {
+		if !strings.HasPrefix(p, "{") || !strings.HasSuffix(p, "}") {
+			if strings.ContainsAny(p, "{}") {
+				return nil, fmt.Errorf("pattern %q: malformed segment %q", pattern, p)
+			}
+			segs = append(segs, segment{kind: literal, value: p})
+			continue
+		}
+
+		s := segment{kind: param, value: p[1 : len(p)-1]}
+		if strings.HasSuffix(s.value, "...") {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("pattern %q: wildcard %q isn't last", pattern, p)
+			}
+			s.kind, s.value = wildcard, strings.TrimSuffix(s.value, "...")
+		}
+		if s.value == "" || strings.ContainsAny(s.value, "{}") {
+			return nil, fmt.Errorf("pattern %q: malformed segment %q", pattern, p)
+		}
+		if names[s.value] {
+			return nil, fmt.Errorf("pattern %q: duplicate parameter %q", pattern, s.value)
+		}
+		names[s.value] = true
+		segs = append(segs, s)
+	}
+	return segs, nil
+}
+
+// split returns the segments of the escaped path p, ignoring empty ones.
+func split(p string) []string {
+	var segs []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" {
+			segs = append(segs, s)
+		}
+	}
+	return segs
+}
+
+type table struct {
+	mu     sync.RWMutex
+	routes []*route
+}
+
+// Router dispatches requests to the handler of the route whose pattern takes
+// precedence among those matching the request path. Handlers find the
+// values of pattern parameters with Param. Routers are safe for concurrent
+// use.
+type Router struct {
+	NotFound http.Handler // defaults to http.NotFoundHandler
+
+	table      *table
+	prefix     string
+	middleware []Middleware
+}
+
+// New returns an empty router.
+func New() *Router {
+	return &Router{table: new(table)}
+}
+
+// Use adds middleware wrapping the handlers of routes registered on r and its
+// groups afterward. Middleware added first runs first.
+func (r *Router) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Group returns a router that registers its routes on r's routes with the
+// pattern prefix prepended and mw added to r's middleware.
+func (r *Router) Group(prefix string, mw ...Middleware) *Router {
+	g := &Router{
+		table:      r.table,
+		prefix:     r.prefix + strings.TrimSuffix(prefix, "/"),
+		middleware: make([]Middleware, 0, len(r.middleware)+len(mw)),
+	}
+	g.middleware = append(append(g.middleware, r.middleware...), mw...)
+	return g
+}
+
+// With returns a router that registers its routes on r's routes with mw
+// added to r's middleware, for settings such as timeouts and body limits that
+// apply to single routes:
+//
+//	r.With(middleware.MaxBytes(1<<20)).Method(http.MethodPost, "/chores", h)
+func (r *Router) With(mw ...Middleware) *Router {
+	return r.Group("", mw...)
+}
+
+// Handle registers h for all request methods on pattern. It panics if the
+// pattern is malformed or another route already matches the same paths.
+func (r *Router) Handle(pattern string, h http.Handler) {
+	r.add(pattern, "", h)
+}
+
+// HandleFunc registers f for all request methods on pattern.
+func (r *Router) HandleFunc(pattern string, f func(http.ResponseWriter, *http.Request)) {
+	r.Handle(pattern, http.HandlerFunc(f))
+}
+
+// Method registers h for method requests on pattern. Methods registered on
+// the same pattern share a handlers.Methods, which answers HEAD, OPTIONS and
+// unsupported methods. Each method's handler runs the middleware of the
+// router it was registered on; the OPTIONS and 405 Method Not Allowed
+// responses handlers.Methods generates run the middleware of the router that
+// registered the pattern's first method. It panics if the pattern is
+// malformed or Handle registered the same paths.
+func (r *Router) Method(method, pattern string, h http.Handler) {
+	r.add(pattern, method, h)
+}
+
+func (r *Router) add(pattern, method string, h http.Handler) {
+	pattern = r.prefix + pattern
+	segs, err := parse(pattern)
+	if err != nil {
+		panic("router: " + err.Error())
+	}
+	mw := middleware.Chain(r.middleware...)
+	h = mw(h)
+	n := &route{pattern: pattern, segments: segs, handler: h, middleware: mw}
+
+	t := r.table
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, rt := range t.routes {
+		if rt.shape() != n.shape() {
+			continue
+		}
+		if method == "" || rt.methods == nil {
+			panic(fmt.Sprintf("router: pattern %q conflicts with %q", pattern, rt.pattern))
+		}
+		if _, ok := rt.methods[method]; ok {
+			panic(fmt.Sprintf("router: %s %q already registered", method, pattern))
+		}
+		// requests in flight may be reading the current map
+		methods := handlers.Methods{method: h}
+		for m, mh := range rt.methods {
+			methods[m] = mh
+		}
+		rt.methods, rt.handler = methods, newMethodHandler(methods, rt.middleware)
+		return
+	}
+
+	if method != "" {
+		n.methods = handlers.Methods{method: h}
+		n.handler = newMethodHandler(n.methods, mw)
+	}
+	t.routes = append(t.routes, n)
+	sort.SliceStable(t.routes, func(i, j int) bool { return t.routes[i].less(t.routes[j]) })
+}
+
+// methodHandler serves a route added by Method. The handlers in methods are
+// wrapped in their own middleware already, so only the responses methods
+// generates itself go through the route's middleware.
+type methodHandler struct {
+	methods   handlers.Methods
+	generated http.Handler // methods wrapped in the route's middleware
+}
+
+func newMethodHandler(methods handlers.Methods, mw Middleware) methodHandler {
+	return methodHandler{methods: methods, generated: mw(methods)}
+}
+
+func (h methodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, ok := h.methods[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		_, ok = h.methods[http.MethodGet]
+	}
+	if ok {
+		h.methods.ServeHTTP(w, r)
+		return
+	}
+	h.generated.ServeHTTP(w, r)
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	segs := split(path.Clean("/" + req.URL.EscapedPath()))
+	for i, s := range segs {
+		if u, err := url.PathUnescape(s); err == nil {
+			segs[i] = u
+		}
+	}
+
+	t := r.table
+	t.mu.RLock()
+	for _, rt := range t.routes {
+		params, ok := rt.match(segs)
+		if !ok {
+			continue
+		}
+		h := rt.handler
+		t.mu.RUnlock()
+
+		ctx := context.WithValue(req.Context(), matchKey{},
+			&match{pattern: rt.pattern, params: params})
+		h.ServeHTTP(w, req.WithContext(ctx))
+		return
+	}
+	t.mu.RUnlock()
+
+	if r.NotFound != nil {
+		r.NotFound.ServeHTTP(w, req)
+		return
+	}
+	http.NotFound(w, req)
+}
+
+type matchKey struct{}
+
+type match struct {
+	pattern string
+	params  map[string]string
+}
+
+// Param returns the value of the named pattern parameter or wildcard in the
+// route that matched r, or an empty string.
+func Param(r *http.Request, name string) string {
+	if m, ok := r.Context().Value(matchKey{}).(*match); ok {
+		return m.params[name]
+	}
+	return ""
+}
+
+// Params returns all pattern parameters of the route that matched r.
+func Params(r *http.Request) map[string]string {
+	p := make(map[string]string)
+	if m, ok := r.Context().Value(matchKey{}).(*match); ok {
+		for k, v := range m.params {
+			p[k] = v
+		}
+	}
+	return p
+}
+
+// Pattern returns the pattern of the route that matched r, which makes a
+// better metric label than the path.
+func Pattern(r *http.Request) string {
+	if m, ok := r.Context().Value(matchKey{}).(*match); ok {
+		return m.pattern
+	}
+	return ""
+}
@@ -0,0 +1,266 @@
+package router
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go-network/chapter09/middleware"
+)
+
+// describe answers with the matched pattern and its parameters.
+func describe(w http.ResponseWriter, r *http.Request) {
+	params := Params(r)
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := []string{Pattern(r)}
+	for _, k := range keys {
+		out = append(out, fmt.Sprintf("%s=%s", k, params[k]))
+	}
+	_, _ = fmt.Fprint(w, strings.Join(out, " "))
+}
+
+func serve(h http.Handler, method, target string) (int, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	b, _ := ioutil.ReadAll(w.Result().Body)
+	return w.Code, string(b)
+}
+
+func TestPrecedence(t *testing.T) {
+	r := New()
+	// registration order doesn't matter
+	for _, p := range []string{
+		"/{rest...}",
+		"/",
+		"/hello",
+		"/hello/there",
+		"/chores/{id}",
+		"/chores/new",
+		"/chores/{id}/{action}",
+		"/chores/{id}/done",
+		"/chores/{rest...}",
+		"/{a}/{b}/static",
+		"/files/{path...}",
+	} {
+		r.HandleFunc(p, describe)
+	}
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"/", "/"},
+		{"/hello", "/hello"},
+		{"/hello/", "/hello"}, // no redirect for trailing slashes
+		{"/hello/there", "/hello/there"},
+		{"/hello/you", "/{rest...} rest=hello/you"}, // not a prefix match on /hello
+		{"/chores/new", "/chores/new"},
+		{"/chores/42", "/chores/{id} id=42"},
+		{"/chores/42/done", "/chores/{id}/done id=42"},
+		{"/chores/42/undo", "/chores/{id}/{action} action=undo id=42"},
+		{"/chores/42/done/twice", "/chores/{rest...} rest=42/done/twice"},
+		{"/chores/a/static", "/chores/{id}/{action} action=static id=a"}, // leftmost literal wins
+		{"/x/y/static", "/{a}/{b}/static a=x b=y"},
+		{"/files", "/files/{path...} path="},
+		{"/files/a/b.txt", "/files/{path...} path=a/b.txt"},
+		{"/chores/a%2Fb", "/chores/{id} id=a/b"}, // escaped slashes stay in the segment
+		{"/chores//42/../43", "/chores/{id} id=43"},
+	}
+	for _, c := range testCases {
+		code, body := serve(r, http.MethodGet, c.path)
+		if code != http.StatusOK {
+			t.Errorf("%s: unexpected status %d", c.path, code)
+		}
+		if body != c.expected {
+			t.Errorf("%s: expected %q; actual %q", c.path, c.expected, body)
+		}
+	}
+}
+
+func TestMethods(t *testing.T) {
+	r := New()
+	r.Method(http.MethodGet, "/chores/{id}", http.HandlerFunc(describe))
+	r.Method(http.MethodDelete, "/chores/{id}", http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }))
+
+	testCases := []struct {
+		method string
+		code   int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodHead, http.StatusOK},
+		{http.MethodDelete, http.StatusNoContent},
+		{http.MethodOptions, http.StatusOK},
+		{http.MethodPut, http.StatusMethodNotAllowed},
+	}
+	for _, c := range testCases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(c.method, "/chores/1", nil))
+		if w.Code != c.code {
+			t.Errorf("%s: expected status %d; actual %d", c.method, c.code, w.Code)
+		}
+		if c.code == http.StatusMethodNotAllowed || c.method == http.MethodOptions {
+			if allow := w.Header().Get("Allow"); allow != "DELETE, GET, HEAD, OPTIONS" {
+				t.Errorf("%s: unexpected Allow %q", c.method, allow)
+			}
+		}
+	}
+
+	if code, _ := serve(r, http.MethodGet, "/chores"); code != http.StatusNotFound {
+		t.Errorf("expected status %d; actual %d", http.StatusNotFound, code)
+	}
+}
+
+// tag is middleware recording that it ran in the X-Middleware header.
+func tag(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Middleware", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestMethodsMiddleware(t *testing.T) {
+	r := New()
+	api := r.Group("/api", tag("api"))
+	api.Method(http.MethodGet, "/chores", http.HandlerFunc(describe))
+	api.With(tag("post")).Method(http.MethodPost, "/chores", http.HandlerFunc(describe))
+
+	testCases := []struct {
+		method string
+		code   int
+		mw     string
+	}{
+		{http.MethodGet, http.StatusOK, "api"},
+		{http.MethodHead, http.StatusOK, "api"},
+		{http.MethodPost, http.StatusOK, "api,post"},
+		// generated by handlers.Methods, yet still wrapped by the group
+		{http.MethodOptions, http.StatusOK, "api"},
+		{http.MethodPut, http.StatusMethodNotAllowed, "api"},
+	}
+	for _, c := range testCases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(c.method, "/api/chores", nil))
+		if w.Code != c.code {
+			t.Errorf("%s: expected status %d; actual %d", c.method, c.code, w.Code)
+		}
+		if mw := strings.Join(w.Header()["X-Middleware"], ","); mw != c.mw {
+			t.Errorf("%s: expected middleware %s; actual %s", c.method, c.mw, mw)
+		}
+	}
+}
+
+func TestGroup(t *testing.T) {
+	r := New()
+	r.Use(tag("root"))
+	api := r.Group("/api/", tag("api"))
+	v1 := api.Group("/v1", tag("v1"))
+	v1.HandleFunc("/chores/{id}", describe)
+	r.HandleFunc("/", describe)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/chores/7", nil))
+	if body := w.Body.String(); body != "/api/v1/chores/{id} id=7" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if mw := strings.Join(w.Header()["X-Middleware"], ","); mw != "root,api,v1" {
+		t.Errorf("expected middleware root,api,v1; actual %s", mw)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if mw := strings.Join(w.Header()["X-Middleware"], ","); mw != "root" {
+		t.Errorf("expected middleware root; actual %s", mw)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	r := New()
+	r.HandleFunc("/hello", describe)
+	if code, _ := serve(r, http.MethodGet, "/goodbye"); code != http.StatusNotFound {
+		t.Errorf("expected status %d; actual %d", http.StatusNotFound, code)
+	}
+
+	r.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	if code, _ := serve(r, http.MethodGet, "/goodbye"); code != http.StatusTeapot {
+		t.Errorf("expected status %d; actual %d", http.StatusTeapot, code)
+	}
+}
+
+func TestInvalidPatterns(t *testing.T) {
+	for _, c := range []struct {
+		first, second string
+	}{
+		{"", "hello"},                      // no leading slash
+		{"", "/{id"},                       // unbalanced brace
+		{"", "/{}"},                        // unnamed parameter
+		{"", "/{rest...}/more"},            // wildcard not last
+		{"", "/{id}/{id}"},                 // duplicate name
+		{"/chores/{id}", "/chores/{name}"}, // same paths
+		{"/chores/", "/chores"},            // trailing slashes are ignored
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%q then %q: expected panic", c.first, c.second)
+				}
+			}()
+			r := New()
+			if c.first != "" {
+				r.HandleFunc(c.first, describe)
+			}
+			r.HandleFunc(c.second, describe)
+		}()
+	}
+}
+
+func TestWith(t *testing.T) {
+	read := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ioutil.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+	wait := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(100 * time.Millisecond):
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	r := New()
+	r.With(middleware.MaxBytes(4)).Method(http.MethodPost, "/small", read)
+	r.With(middleware.MaxBytes(1<<10)).Method(http.MethodPost, "/large", read)
+	r.With(middleware.Timeout(10*time.Millisecond)).Handle("/fast", wait)
+	r.Handle("/slow", wait)
+
+	testCases := []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodPost, "/small", "12345", http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "/large", "12345", http.StatusOK},
+		{http.MethodGet, "/fast", "", http.StatusServiceUnavailable},
+		{http.MethodGet, "/slow", "", http.StatusNoContent},
+	}
+	for _, c := range testCases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(c.method, c.path, strings.NewReader(c.body)))
+		if w.Code != c.code {
+			t.Errorf("%s: expected status %d; actual %d", c.path, c.code, w.Code)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package chapter09
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"go-network/chapter09/graceful"
 	"go-network/chapter09/handlers"
 	"io"
 	"io/ioutil"
@@ -24,12 +26,11 @@ func TestSimpleHTTPServer(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	go func() {
-		err := srv.Serve(l)
-		if err != http.ErrServerClosed {
-			t.Error(err)
-		}
-	}()
+	runner := &graceful.Runner{ShutdownTimeout: 5 * time.Second}
+	runner.Add(srv, l)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- runner.Run(ctx) }()
 
 	testCases := []struct {
 		method   string
@@ -70,7 +71,8 @@ func TestSimpleHTTPServer(t *testing.T) {
 			t.Errorf("%d: expected %q; actual %q", i, c.response, b)
 		}
 	}
-	if err := srv.Close(); err != nil {
+	cancel()
+	if err := <-done; err != nil {
 		t.Fatal(err)
 	}
 }
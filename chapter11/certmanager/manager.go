@@ -0,0 +1,189 @@
+// Package certmanager keeps a TLS certificate loaded from files current, so
+// servers and clients pick up rotated certificates without restarting.
+package certmanager
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// debounce is how long Watch waits for writes to settle, since tools
+// replacing a key pair touch both files, often in several steps.
+const debounce = 100 * time.Millisecond
+
+var (
+	ErrExpired     = errors.New("certificate has expired")
+	ErrNotYetValid = errors.New("certificate is not yet valid")
+)
+
+// Manager serves the key pair in its certificate and key files. Reload
+// replaces the pair only if the new one is valid, so a half-written or
+// mismatched pair never reaches a handshake.
+type Manager struct {
+	certFn, keyFn string
+	Logger        *log.Logger // defaults to discarding log output
+
+	cert atomic.Value // *tls.Certificate
+	mu   sync.Mutex   // serializes reloads
+}
+
+// New loads the key pair and returns a Manager serving it.
+func New(certFn, keyFn string) (*Manager, error) {
+	m := &Manager{certFn: certFn, keyFn: keyFn}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload loads the key pair from the files and, if it's valid, starts
+// serving it. On error the previous pair stays in use.
+func (m *Manager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cert, err := tls.LoadX509KeyPair(m.certFn, m.keyFn)
+	if err != nil {
+		return fmt.Errorf("loading key pair: %w", err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return fmt.Errorf("parsing certificate: %w", err)
+	}
+
+	now := time.Now()
+	if now.After(cert.Leaf.NotAfter) {
+		return fmt.Errorf("%s: %w on %s", m.certFn, ErrExpired, cert.Leaf.NotAfter)
+	}
+	if now.Before(cert.Leaf.NotBefore) {
+		return fmt.Errorf("%s: %w until %s", m.certFn, ErrNotYetValid, cert.Leaf.NotBefore)
+	}
+
+	m.cert.Store(&cert)
+	m.logger().Printf("loaded certificate %q, serial %s, expiring %s",
+		cert.Leaf.Subject.CommonName, cert.Leaf.SerialNumber, cert.Leaf.NotAfter)
+	return nil
+}
+
+// Certificate returns the key pair in use.
+func (m *Manager) Certificate() *tls.Certificate {
+	return m.cert.Load().(*tls.Certificate)
+}
+
+// NotAfter returns the expiry of the certificate in use, for alerting before
+// a failed rotation turns into failed handshakes.
+func (m *Manager) NotAfter() time.Time {
+	return m.Certificate().Leaf.NotAfter
+}
+
+// GetCertificate serves the key pair as tls.Config.GetCertificate.
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.Certificate(), nil
+}
+
+// GetClientCertificate serves the key pair as
+// tls.Config.GetClientCertificate.
+func (m *Manager) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return m.Certificate(), nil
+}
+
+// Watch reloads the key pair whenever its files change, until ctx is
+// canceled. It watches the files' directories rather than the files, so it
+// notices files replaced by renames, as well as Kubernetes-style secret
+// volumes that swap a "..data" symlink. Failed reloads are logged.
+func (m *Manager) Watch(ctx context.Context) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	watched := map[string]bool{}
+	for _, fn := range []string{m.certFn, m.keyFn} {
+		dir := filepath.Dir(fn)
+		if watched[dir] {
+			continue
+		}
+		if err = w.Add(dir); err != nil {
+			return err
+		}
+		watched[dir] = true
+	}
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if m.relevant(event.Name) {
+				timer.Reset(debounce)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			m.logger().Printf("watching certificates: %v", err)
+		case <-timer.C:
+			if err := m.Reload(); err != nil {
+				m.logger().Printf("reloading certificate: %v", err)
+			}
+		}
+	}
+}
+
+func (m *Manager) relevant(name string) bool {
+	name = filepath.Clean(name)
+	return name == filepath.Clean(m.certFn) || name == filepath.Clean(m.keyFn) ||
+		strings.HasPrefix(filepath.Base(name), "..")
+}
+
+// ReloadOnSignal reloads the key pair whenever the process receives one of
+// the signals, SIGHUP by default, until ctx is canceled. Failed reloads are
+// logged.
+func (m *Manager) ReloadOnSignal(ctx context.Context, signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	defer signal.Stop(c)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c:
+			if err := m.Reload(); err != nil {
+				m.logger().Printf("reloading certificate: %v", err)
+			}
+		}
+	}
+}
+
+func (m *Manager) logger() *log.Logger {
+	if m.Logger == nil {
+		return log.New(ioutil.Discard, "", 0)
+	}
+	return m.Logger
+}
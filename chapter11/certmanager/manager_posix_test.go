@@ -0,0 +1,45 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package certmanager
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadOnSignal(t *testing.T) {
+	certFn, keyFn := paths(t)
+	now := time.Now()
+	writePair(t, certFn, keyFn, 1, now.Add(-time.Hour), now.Add(time.Hour))
+	m, err := New(certFn, keyFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keeps the signal's default action, terminating the process, from
+	// applying before ReloadOnSignal registers
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR2)
+	defer signal.Stop(c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{})
+	go func() {
+		close(started)
+		m.ReloadOnSignal(ctx, syscall.SIGUSR2)
+	}()
+	<-started
+	time.Sleep(50 * time.Millisecond) // let it register for the signal
+
+	writePair(t, certFn, keyFn, 2, now.Add(-time.Hour), now.Add(time.Hour))
+	if err = syscall.Kill(os.Getpid(), syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	waitForSerial(t, m, 2)
+}
@@ -0,0 +1,117 @@
+package certmanager
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-network/internal/testcert"
+)
+
+// writePair writes a self-signed key pair with the serial number, valid from
+// notBefore to notAfter, to certFn and keyFn.
+func writePair(t *testing.T, certFn, keyFn string, serial int64, notBefore, notAfter time.Time) {
+	t.Helper()
+	cert := testcert.Generate(t, testcert.Options{
+		Hosts:     []string{"localhost"},
+		Serial:    serial,
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+	})
+	testcert.Write(t, cert, certFn, keyFn)
+}
+
+func paths(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	return filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+}
+
+func serial(m *Manager) int64 {
+	c, _ := m.GetCertificate(&tls.ClientHelloInfo{})
+	return c.Leaf.SerialNumber.Int64()
+}
+
+func TestReload(t *testing.T) {
+	certFn, keyFn := paths(t)
+	now := time.Now()
+	writePair(t, certFn, keyFn, 1, now.Add(-time.Hour), now.Add(time.Hour))
+
+	m, err := New(certFn, keyFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.NotAfter().Equal(now.Add(time.Hour).Truncate(time.Second)) {
+		t.Errorf("unexpected expiry %s", m.NotAfter())
+	}
+
+	writePair(t, certFn, keyFn, 2, now.Add(-time.Hour), now.Add(2*time.Hour))
+	if err = m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if s := serial(m); s != 2 {
+		t.Errorf("expected serial 2; actual %d", s)
+	}
+	c, _ := m.GetClientCertificate(&tls.CertificateRequestInfo{})
+	if c != m.Certificate() {
+		t.Error("client and server certificates differ")
+	}
+
+	// invalid pairs leave the current one in place
+	writePair(t, certFn, keyFn, 3, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if err = m.Reload(); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected %v; actual %v", ErrExpired, err)
+	}
+	writePair(t, certFn, keyFn, 4, now.Add(time.Hour), now.Add(2*time.Hour))
+	if err = m.Reload(); !errors.Is(err, ErrNotYetValid) {
+		t.Errorf("expected %v; actual %v", ErrNotYetValid, err)
+	}
+	otherCert, otherKey := paths(t)
+	writePair(t, otherCert, otherKey, 5, now.Add(-time.Hour), now.Add(time.Hour))
+	if err = os.Rename(otherKey, keyFn); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Reload(); err == nil {
+		t.Error("expected an error for a mismatched key")
+	}
+	if s := serial(m); s != 2 {
+		t.Errorf("expected serial 2 to stay in use; actual %d", s)
+	}
+}
+
+func waitForSerial(t *testing.T, m *Manager, expected int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for serial(m) != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected serial %d; actual %d", expected, serial(m))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	certFn, keyFn := paths(t)
+	now := time.Now()
+	writePair(t, certFn, keyFn, 1, now.Add(-time.Hour), now.Add(time.Hour))
+	m, err := New(certFn, keyFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- m.Watch(ctx) }()
+	time.Sleep(50 * time.Millisecond) // let the watcher start
+
+	writePair(t, certFn, keyFn, 2, now.Add(-time.Hour), now.Add(time.Hour))
+	waitForSerial(t, m, 2)
+
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
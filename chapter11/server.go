@@ -0,0 +1,353 @@
+package chapter11
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"go-network/chapter11/certmanager"
+)
+
+// DefaultHandshakeTimeout bounds the TLS handshake of each connection.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+var ErrServerClosed = errors.New("tls server closed")
+
+// Handler serves a connection whose TLS handshake completed. The context is
+// canceled when the server shuts down; the server closes conn once
+// ServeConn returns.
+type Handler interface {
+	ServeConn(ctx context.Context, conn *tls.Conn)
+}
+
+type HandlerFunc func(ctx context.Context, conn *tls.Conn)
+
+func (f HandlerFunc) ServeConn(ctx context.Context, conn *tls.Conn) { f(ctx, conn) }
+
+type Server struct {
+	ctx       context.Context
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	addr      string
+	maxIdle   time.Duration
+	tlsConfig *tls.Config
+	certs     *certmanager.Manager
+
+	// Handler serves each connection; defaults to EchoHandler(maxIdle).
+	Handler Handler
+	// MaxConns caps the connections served at once. Once reached, the
+	// server stops accepting, leaving new clients in the listen backlog.
+	// Zero means no limit.
+	MaxConns int
+	// HandshakeTimeout bounds each TLS handshake, separately from the idle
+	// timeout the handler applies afterward. Defaults to
+	// DefaultHandshakeTimeout.
+	HandshakeTimeout time.Duration
+	Logger           *log.Logger // defaults to discarding log output
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	connCtx  context.Context
+	cancel   context.CancelFunc
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func NewTLSServer(ctx context.Context, address string, maxIdle time.Duration, tlsConfig *tls.Config) *Server {
+	return &Server{
+		ctx:   ctx,
+		ready: make(chan struct{}),
+		addr:  address,
+		// 针对的是conn对象,就是tcp会话
+		maxIdle:   maxIdle,
+		tlsConfig: tlsConfig,
+	}
+}
+
+// Ready blocks until the server accepts connections or failed to start.
+func (s *Server) Ready() {
+	if s.ready != nil {
+		<-s.ready
+	}
+
+}
+
+func (s *Server) markReady() {
+	s.readyOnce.Do(func() {
+		if s.ready != nil {
+			close(s.ready)
+		}
+	})
+}
+
+// ListenAndServerTLS method accepts full paths to a certificate and a
+// private key and returns an error.
+func (s *Server) ListenAndServerTLS(certFn, keyFn string) error {
+	if s.addr == "" {
+		s.addr = "localhost:443"
+	}
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("binding to tcp %s: %w", s.addr, err)
+	}
+	return s.ServeTLS(l, certFn, keyFn)
+}
+
+// ServeTLS performs the TLS handshake on connections accepted from l and
+// hands them to the Handler. Unless the TLS configuration provides
+// certificates, the server loads certFn and keyFn and reloads them when
+// either file changes. It returns nil once the server's context is
+// canceled, and ErrServerClosed after Shutdown or Close.
+func (s *Server) ServeTLS(l net.Listener, certFn, keyFn string) error {
+	defer s.markReady()
+	if err := s.configure(certFn, keyFn); err != nil {
+		_ = l.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	parent := s.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	s.listener = l
+	s.conns = make(map[net.Conn]struct{})
+	s.connCtx, s.cancel = context.WithCancel(parent)
+	ctx := s.connCtx
+	s.mu.Unlock()
+
+	go func() {
+		// stops the server when its context is canceled; a no-op after
+		// Shutdown or Close
+		<-ctx.Done()
+		_ = s.stop()
+	}()
+	if s.certs != nil {
+		go func() {
+			if err := s.certs.Watch(ctx); err != nil {
+				s.Logger.Printf("watching %s and %s: %v", certFn, keyFn, err)
+			}
+		}()
+	}
+	s.markReady()
+
+	var sem chan struct{}
+	if s.MaxConns > 0 {
+		sem = make(chan struct{}, s.MaxConns)
+	}
+
+	for {
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return s.closedErr()
+			}
+		}
+
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return s.closedErr()
+			}
+			if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+				// out of file descriptors; give handlers a chance to finish
+				s.Logger.Printf("accept: %v", err)
+				time.Sleep(10 * time.Millisecond)
+				if sem != nil {
+					<-sem
+				}
+				continue
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+
+		if !s.track(conn) {
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer func() {
+				s.untrack(conn)
+				if sem != nil {
+					<-sem
+				}
+			}()
+			s.serve(ctx, conn)
+		}()
+	}
+}
+
+// configure fills in the defaults. The certificate manager is set on a copy
+// of the caller's configuration, which may be shared with other servers.
+func (s *Server) configure(certFn, keyFn string) error {
+	if s.Logger == nil {
+		s.Logger = log.New(ioutil.Discard, "", 0)
+	}
+	if s.Handler == nil {
+		s.Handler = EchoHandler(s.maxIdle)
+	}
+	if s.HandshakeTimeout <= 0 {
+		s.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+
+	if s.tlsConfig == nil {
+		s.tlsConfig = &tls.Config{
+			CurvePreferences: []tls.CurveID{tls.CurveP256},
+			MinVersion:       tls.VersionTLS12,
+			// noticePreferServerCipherSuites is meaningful to the server only, and it makes the
+			//server use its preferred cipher suite instead of deferring to the client’s
+			//preference.
+			PreferServerCipherSuites: true,
+		}
+	}
+
+	if len(s.tlsConfig.Certificates) == 0 && s.tlsConfig.GetCertificate == nil {
+		certs, err := certmanager.New(certFn, keyFn)
+		if err != nil {
+			return err
+		}
+		certs.Logger = s.Logger
+		s.mu.Lock()
+		s.certs = certs
+		s.mu.Unlock()
+		s.tlsConfig = s.tlsConfig.Clone()
+		s.tlsConfig.GetCertificate = certs.GetCertificate
+	}
+	return nil
+}
+
+// serve completes the handshake on conn within the handshake timeout and
+// runs the handler.
+func (s *Server) serve(ctx context.Context, raw net.Conn) {
+	conn := tls.Server(raw, s.tlsConfig)
+
+	hsCtx, cancel := context.WithTimeout(ctx, s.HandshakeTimeout)
+	_ = conn.SetDeadline(time.Now().Add(s.HandshakeTimeout))
+	err := conn.HandshakeContext(hsCtx)
+	cancel()
+	if err != nil {
+		s.Logger.Printf("handshake with %s: %v", raw.RemoteAddr(), err)
+		return
+	}
+	// the handler sets its own deadlines, such as the idle timeout
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		return
+	}
+
+	s.Handler.ServeConn(ctx, conn)
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	_ = conn.Close()
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// closedErr tells a canceled context, which ends serving cleanly, from an
+// explicit Shutdown or Close.
+func (s *Server) closedErr() error {
+	if s.ctx != nil && s.ctx.Err() != nil {
+		return nil
+	}
+	return ErrServerClosed
+}
+
+// Certificates returns the manager of the certificate loaded from the files
+// passed to ServeTLS, or nil if the TLS configuration provides certificates.
+// Use it to reload on a signal or to check the certificate's expiry.
+func (s *Server) Certificates() *certmanager.Manager {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.certs
+}
+
+// Address returns the address the server listens on, or nil before it
+// serves.
+func (s *Server) Address() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Shutdown stops accepting connections and cancels the context passed to
+// handlers. It then waits for the handlers to return. If ctx ends first,
+// Shutdown closes the remaining connections and returns ctx's error.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.stop()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		s.closeConns()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Close stops the server and closes all connections immediately.
+func (s *Server) Close() error {
+	err := s.stop()
+	s.closeConns()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.listener == nil {
+		return nil
+	}
+	s.cancel()
+	return s.listener.Close()
+}
+
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
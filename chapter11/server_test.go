@@ -0,0 +1,243 @@
+package chapter11
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-network/internal/testcert"
+)
+
+// startServer serves s on a random port and returns a client configuration
+// trusting it, and a channel receiving ServeTLS's result.
+func startServer(t *testing.T, s *Server) (*tls.Config, <-chan error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.ServeTLS(l, "", "") }()
+	s.Ready()
+	t.Cleanup(func() { _ = s.Close() })
+
+	pool := x509.NewCertPool()
+	pool.AddCert(s.tlsConfig.Certificates[0].Leaf)
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, done
+}
+
+func newTestServer(t *testing.T, ctx context.Context, maxIdle time.Duration) *Server {
+	cert := testcert.New(t, "127.0.0.1")
+	return NewTLSServer(ctx, "", maxIdle, &tls.Config{Certificates: []tls.Certificate{*cert}})
+}
+
+func echo(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != msg {
+		t.Fatalf("expected %q; actual %q", msg, buf)
+	}
+}
+
+func TestServerDefaults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newTestServer(t, ctx, 0)
+	cfg, done := startServer(t, s)
+
+	conn, err := tls.Dial("tcp", s.Address().String(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	echo(t, conn, "hello")
+	if s.Handler == nil || s.HandshakeTimeout != DefaultHandshakeTimeout {
+		t.Error("defaults weren't kept on the server")
+	}
+
+	// canceling the context ends serving without an error
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatalf("expected nil; actual %v", err)
+	}
+}
+
+func TestServerHandler(t *testing.T) {
+	s := newTestServer(t, nil, 0)
+	s.Handler = HandlerFunc(func(_ context.Context, conn *tls.Conn) {
+		if conn.ConnectionState().HandshakeComplete {
+			_, _ = conn.Write([]byte("handled"))
+		}
+	})
+	cfg, _ := startServer(t, s)
+
+	conn, err := tls.Dial("tcp", s.Address().String(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "handled" {
+		t.Errorf("expected %q; actual %q", "handled", b)
+	}
+}
+
+func TestServerMaxConns(t *testing.T) {
+	s := newTestServer(t, nil, 0)
+	s.MaxConns = 1
+	cfg, _ := startServer(t, s)
+	addr := s.Address().String()
+
+	first, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, first, "first")
+
+	// the second client connects to the backlog, but its handshake waits
+	// for the first connection to end
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	second := tls.Client(raw, cfg)
+	_ = second.SetDeadline(time.Now().Add(100 * time.Millisecond))
+	if err = second.Handshake(); err == nil {
+		t.Fatal("expected the second handshake to wait")
+	}
+	_ = raw.Close()
+
+	_ = first.Close()
+	third, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	_ = third.SetDeadline(time.Now().Add(time.Second))
+	// the abandoned second connection is accepted first; its handshake
+	// fails and frees the slot
+	echo(t, third, "third")
+}
+
+func TestServerHandshakeTimeout(t *testing.T) {
+	s := newTestServer(t, nil, time.Minute)
+	s.HandshakeTimeout = 50 * time.Millisecond
+	startServer(t, s)
+
+	conn, err := net.Dial("tcp", s.Address().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// never starting the handshake gets the connection closed long before
+	// the idle timeout
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF; actual %v", err)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	s := newTestServer(t, nil, 0)
+	cfg, done := startServer(t, s)
+
+	conn, err := tls.Dial("tcp", s.Address().String(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	echo(t, conn, "before shutdown")
+
+	// the echo handler stops reading once the server shuts down
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != ErrServerClosed {
+		t.Fatalf("expected %v; actual %v", ErrServerClosed, err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF; actual %v", err)
+	}
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	s := newTestServer(t, nil, 0)
+	s.Handler = HandlerFunc(func(_ context.Context, conn *tls.Conn) {
+		// ignores shutdown and blocks until its connection is closed
+		_, _ = conn.Read(make([]byte, 1))
+	})
+	cfg, _ := startServer(t, s)
+
+	conn, err := tls.Dial("tcp", s.Address().String(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond) // let the handler start
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v; actual %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestServerCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFn, keyFn := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first := testcert.New(t, "127.0.0.1")
+	testcert.Write(t, first, certFn, keyFn)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewTLSServer(nil, "", 0, nil)
+	go func() { _ = s.ServeTLS(l, certFn, keyFn) }()
+	s.Ready()
+	defer s.Close()
+
+	served := func() []byte {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Raw
+	}
+	if !bytes.Equal(served(), first.Certificate[0]) {
+		t.Fatal("unexpected certificate")
+	}
+	if s.Certificates() == nil || !s.Certificates().NotAfter().Equal(first.Leaf.NotAfter) {
+		t.Error("expected the manager to report the certificate's expiry")
+	}
+
+	second := testcert.New(t, "127.0.0.1")
+	testcert.Write(t, second, certFn, keyFn)
+	deadline := time.Now().Add(5 * time.Second)
+	for !bytes.Equal(served(), second.Certificate[0]) {
+		if time.Now().After(deadline) {
+			t.Fatal("rotated certificate wasn't picked up")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
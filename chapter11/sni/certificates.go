@@ -0,0 +1,113 @@
+// Package sni serves several host names from one address: Certificates picks
+// the certificate for the server name a client asks for, and Router forwards
+// TLS connections to backends by server name without terminating TLS.
+package sni
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var ErrNoCertificate = errors.New("no certificate for server name")
+
+// Source provides a certificate for a handshake. *certmanager.Manager is a
+// Source, so certificates selected by name still reload from their files.
+type Source interface {
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+type staticSource struct {
+	cert *tls.Certificate
+}
+
+func (s staticSource) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.cert, nil
+}
+
+// Certificates selects a certificate by the client's server name. Names are
+// exact host names or wildcards like "*.example.com", which match a single
+// label as in RFC 6125: "a.example.com" but neither "example.com" nor
+// "a.b.example.com". Exact names take precedence over wildcards. Clients
+// that send no server name, or one without a match, get Default.
+type Certificates struct {
+	Default Source
+
+	mu      sync.RWMutex
+	sources map[string]Source
+}
+
+// Add serves the certificates from src for name.
+func (c *Certificates) Add(name string, src Source) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sources == nil {
+		c.sources = make(map[string]Source)
+	}
+	c.sources[normalize(name)] = src
+}
+
+// AddCertificate serves cert for each name it's valid for: its DNS names,
+// or its common name if it has none.
+func (c *Certificates) AddCertificate(cert *tls.Certificate) error {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("parsing certificate: %w", err)
+		}
+	}
+	names := leaf.DNSNames
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+	if len(names) == 0 {
+		return errors.New("certificate has no DNS names")
+	}
+	for _, name := range names {
+		c.Add(name, staticSource{cert: cert})
+	}
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *Certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	key, ok := match(hello.ServerName, func(k string) bool {
+		_, ok := c.sources[k]
+		return ok
+	})
+	src := c.sources[key]
+	c.mu.RUnlock()
+
+	if !ok {
+		if c.Default == nil {
+			return nil, fmt.Errorf("%w %q", ErrNoCertificate, hello.ServerName)
+		}
+		src = c.Default
+	}
+	return src.GetCertificate(hello)
+}
+
+// match returns the key that name matches, given a test for whether a
+// normalized host name or single-label wildcard is a key.
+func match(name string, has func(string) bool) (string, bool) {
+	name = normalize(name)
+	if name == "" {
+		return "", false
+	}
+	if has(name) {
+		return name, true
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 && has("*"+name[i:]) {
+		return "*" + name[i:], true
+	}
+	return "", false
+}
+
+func normalize(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
@@ -0,0 +1,174 @@
+package sni
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"go-network/chapter04"
+)
+
+// DefaultHelloTimeout bounds the wait for a client's ClientHello.
+const DefaultHelloTimeout = 5 * time.Second
+
+var errHelloRead = errors.New("client hello read")
+
+// Router forwards TLS connections to backends by the server name in the
+// ClientHello, without terminating TLS: the backends hold the certificates
+// and see the client's handshake unchanged.
+type Router struct {
+	// Default receives connections whose server name has no route, including
+	// clients that send none. If empty, those connections are closed.
+	Default      string
+	HelloTimeout time.Duration // defaults to DefaultHelloTimeout
+	DialTimeout  time.Duration // 0 means no timeout
+	Logger       *log.Logger   // defaults to discarding log output
+
+	mu     sync.RWMutex
+	routes map[string]string
+}
+
+// Route forwards connections for name, an exact host name or a single-label
+// wildcard like "*.example.com", to the backend address. Exact names take
+// precedence over wildcards.
+func (r *Router) Route(name, backend string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.routes == nil {
+		r.routes = make(map[string]string)
+	}
+	r.routes[normalize(name)] = backend
+}
+
+// Backend returns the backend address for a server name, or "" if there's
+// none.
+func (r *Router) Backend(serverName string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := match(serverName, func(k string) bool {
+		_, ok := r.routes[k]
+		return ok
+	})
+	if !ok {
+		return r.Default
+	}
+	return r.routes[key]
+}
+
+// Serve forwards connections accepted from l until ctx is canceled, when it
+// closes l and returns nil. Connections already forwarded stay open until
+// both ends close them or forwarding in either direction fails.
+func (r *Router) Serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+		go r.ServeConn(ctx, conn)
+	}
+}
+
+// ServeConn reads the ClientHello from conn, connects to the matching
+// backend, replays the ClientHello to it and proxies the rest of the
+// connection in both directions. It closes conn when done.
+func (r *Router) ServeConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	timeout := r.HelloTimeout
+	if timeout <= 0 {
+		timeout = DefaultHelloTimeout
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	hello, peeked, err := peekClientHello(conn)
+	if err != nil {
+		r.logger().Printf("%s: reading ClientHello: %v", conn.RemoteAddr(), err)
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	backend := r.Backend(hello.ServerName)
+	if backend == "" {
+		r.logger().Printf("%s: no route for %q", conn.RemoteAddr(), hello.ServerName)
+		return
+	}
+
+	if r.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.DialTimeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	upstream, err := d.DialContext(ctx, "tcp", backend)
+	if err != nil {
+		r.logger().Printf("%s: dialing %s for %q: %v", conn.RemoteAddr(), backend, hello.ServerName, err)
+		return
+	}
+	defer upstream.Close()
+
+	if _, err = upstream.Write(peeked); err != nil {
+		r.logger().Printf("%s: forwarding ClientHello to %s: %v", conn.RemoteAddr(), backend, err)
+		return
+	}
+	if err = chapter04.Proxy(conn, upstream); err != nil {
+		r.logger().Printf("%s: proxying to %s: %v", conn.RemoteAddr(), backend, err)
+	}
+}
+
+func (r *Router) logger() *log.Logger {
+	if r.Logger == nil {
+		return log.New(ioutil.Discard, "", 0)
+	}
+	return r.Logger
+}
+
+// peekClientHello parses the ClientHello from conn and returns it with the
+// bytes read, which the backend needs to see. crypto/tls does the parsing:
+// the handshake is aborted as soon as the ClientHello is known, and nothing
+// is ever written to the client.
+func peekClientHello(conn net.Conn) (*tls.ClientHelloInfo, []byte, error) {
+	var (
+		buf   bytes.Buffer
+		hello *tls.ClientHelloInfo
+	)
+	err := tls.Server(readOnlyConn{r: io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = h
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if hello == nil {
+		return nil, nil, err
+	}
+	return hello, buf.Bytes(), nil
+}
+
+// readOnlyConn feeds the handshake; writes, such as alerts, fail and never
+// reach the client.
+type readOnlyConn struct {
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)       { return c.r.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error)      { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                     { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr              { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr             { return nil }
+func (c readOnlyConn) SetDeadline(time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(time.Time) error { return nil }
@@ -0,0 +1,172 @@
+package sni
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go-network/chapter11"
+	"go-network/internal/testcert"
+)
+
+func TestCertificates(t *testing.T) {
+	exact := testcert.New(t, "www.example.com")
+	wildcard := testcert.New(t, "*.example.com")
+	fallback := testcert.New(t, "default")
+
+	c := new(Certificates)
+	for _, cert := range []*tls.Certificate{exact, wildcard} {
+		if err := c.AddCertificate(cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		serverName string
+		expected   *tls.Certificate
+	}{
+		{"www.example.com", exact},
+		{"WWW.Example.com.", exact},
+		{"api.example.com", wildcard},
+		{"a.b.example.com", nil},
+		{"example.com", nil},
+		{"", nil},
+	}
+	for _, tc := range testCases {
+		cert, err := c.GetCertificate(&tls.ClientHelloInfo{ServerName: tc.serverName})
+		if tc.expected == nil {
+			if !errors.Is(err, ErrNoCertificate) {
+				t.Errorf("%q: expected %v; actual %v", tc.serverName, ErrNoCertificate, err)
+			}
+			continue
+		}
+		if cert != tc.expected {
+			t.Errorf("%q: expected %s; actual %v", tc.serverName, tc.expected.Leaf.DNSNames, cert)
+		}
+	}
+
+	c.Default = staticSource{cert: fallback}
+	if cert, _ := c.GetCertificate(&tls.ClientHelloInfo{}); cert != fallback {
+		t.Error("expected the default certificate without a server name")
+	}
+}
+
+// serve runs a chapter11.Server that echoes with the certificates from
+// GetCertificate and returns its address.
+func serve(t *testing.T, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := chapter11.NewTLSServer(context.Background(), "", 0,
+		&tls.Config{GetCertificate: getCertificate})
+	go func() { _ = s.ServeTLS(l, "", "") }()
+	s.Ready()
+	t.Cleanup(func() { _ = s.Close() })
+	return l.Addr().String()
+}
+
+func dial(t *testing.T, addr, serverName string, pool *x509.CertPool) *tls.Conn {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, RootCAs: pool})
+	if err != nil {
+		t.Fatalf("%s: %v", serverName, err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	if _, err = conn.Write([]byte(serverName)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(serverName))
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != serverName {
+		t.Fatalf("expected %q; actual %q", serverName, buf)
+	}
+	return conn
+}
+
+func TestServerSNI(t *testing.T) {
+	a := testcert.New(t, "a.example.com")
+	poolA := testcert.Pool(a)
+	b := testcert.New(t, "*.b.example.com")
+	poolB := testcert.Pool(b)
+	c := new(Certificates)
+	_ = c.AddCertificate(a)
+	_ = c.AddCertificate(b)
+	addr := serve(t, c.GetCertificate)
+
+	// each client verifies against its own name's certificate only
+	dial(t, addr, "a.example.com", poolA)
+	dial(t, addr, "x.b.example.com", poolB)
+
+	_, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "c.example.com", RootCAs: poolA})
+	if err == nil {
+		t.Fatal("expected the handshake for an unknown name to fail")
+	}
+}
+
+func TestRouter(t *testing.T) {
+	a := testcert.New(t, "a.example.com")
+	poolA := testcert.Pool(a)
+	b := testcert.New(t, "*.b.example.com")
+	poolB := testcert.Pool(b)
+	// routed by default, since no route matches its name
+	fallback := testcert.New(t, "other.example.com")
+	poolFallback := testcert.Pool(fallback)
+	static := func(cert *tls.Certificate) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return cert, nil }
+	}
+
+	r := &Router{Default: serve(t, static(fallback)), HelloTimeout: 100 * time.Millisecond}
+	r.Route("a.example.com", serve(t, static(a)))
+	r.Route("*.b.example.com", serve(t, static(b)))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Serve(ctx, l) }()
+	addr := l.Addr().String()
+
+	// the backends terminate TLS, so each client sees its backend's
+	// certificate
+	for _, tc := range []struct {
+		serverName string
+		pool       *x509.CertPool
+	}{
+		{"a.example.com", poolA},
+		{"x.b.example.com", poolB},
+		{"other.example.com", poolFallback},
+	} {
+		conn := dial(t, addr, tc.serverName, tc.pool)
+		if err = conn.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a client that never sends a ClientHello is dropped
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF; actual %v", err)
+	}
+
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
 	"net"
+	"os"
 
 	"google.golang.org/grpc"
 
+	"go-network/chapter11/certmanager"
 	pb "go-network/chapter12/housework/v1"
 )
 
@@ -26,10 +29,22 @@ func main() {
 	rosie := NewRosie()
 	pb.RegisterRobotMaidServer(server, rosie)
 
-	cert, err := tls.LoadX509KeyPair(certFn, keyFn)
+	// rotated certificates take effect on the next handshake, whether the
+	// files change or the process receives SIGHUP
+	certs, err := certmanager.New(certFn, keyFn)
 	if err != nil {
 		log.Fatal(err)
 	}
+	certs.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	ctx := context.Background()
+	go func() {
+		if err := certs.Watch(ctx); err != nil {
+			log.Printf("watching certificates: %v", err)
+		}
+	}()
+	go certs.ReloadOnSignal(ctx)
+	log.Printf("certificate expires %s", certs.NotAfter())
+
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatal(err)
@@ -39,7 +54,7 @@ func main() {
 
 	log.Fatal(server.Serve(tls.NewListener(listener, &tls.Config{
 		// 另一端的服务使用该证书认证这一端的身份
-		Certificates:             []tls.Certificate{cert},
+		GetCertificate:           certs.GetCertificate,
 		PreferServerCipherSuites: true,
 		CurvePreferences:         []tls.CurveID{tls.CurveP256},
 		MinVersion:               tls.VersionTLS13,
@@ -0,0 +1,337 @@
+// Package admin serves the endpoints operators and orchestrators poll:
+// liveness and readiness checks, build information, a JSON status summary
+// and, optionally, the net/http/pprof profiles. Register them on the same
+// mux as the Prometheus handler to keep them off the public listener.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCheckTimeout bounds checks that don't set their own Timeout.
+const DefaultCheckTimeout = time.Second
+
+var ErrCheckTimeout = errors.New("check timed out")
+
+// Check is a named probe of a dependency or of the process itself.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+	// Timeout bounds Run; the check fails if Run hasn't returned by then.
+	// Defaults to DefaultCheckTimeout.
+	Timeout time.Duration
+	// CacheTTL reuses the last result for this long, so frequent probes
+	// don't hammer the dependency. Zero runs the check on every probe.
+	CacheTTL time.Duration
+}
+
+// Result is the outcome of a check's last run.
+type Result struct {
+	Name     string        `json:"name"`
+	Kind     string        `json:"kind"` // "health" or "readiness"
+	OK       bool          `json:"ok"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	Checked  time.Time     `json:"checked_at"`
+}
+
+type check struct {
+	Check
+	kind string
+
+	mu   sync.Mutex
+	last Result
+	// running is closed once the run in progress records its result, so
+	// concurrent probes share a run
+	running chan struct{}
+}
+
+// result returns the cached result or waits for a run. The run doesn't
+// belong to the probe that starts it: it's bounded by the check's timeout
+// only, so a prober that disconnects neither cuts it short nor gets its
+// cancellation cached as a failure for everyone else.
+func (c *check) result(ctx context.Context) Result {
+	c.mu.Lock()
+	if !c.last.Checked.IsZero() && time.Since(c.last.Checked) < c.CacheTTL {
+		defer c.mu.Unlock()
+		return c.last
+	}
+	done := c.running
+	if done == nil {
+		done = make(chan struct{})
+		c.running = done
+		go c.run(done)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return Result{Name: c.Name, Kind: c.kind, Error: ctx.Err().Error(), Checked: time.Now()}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+func (c *check) run(done chan struct{}) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() { errc <- c.Run(ctx) }()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		// don't wait for a check that ignores its context
+		err = ErrCheckTimeout
+	}
+
+	res := Result{
+		Name:     c.Name,
+		Kind:     c.kind,
+		OK:       err == nil,
+		Duration: time.Since(start),
+		Checked:  start,
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	c.mu.Lock()
+	c.last = res
+	c.running = nil
+	c.mu.Unlock()
+	close(done)
+}
+
+// Admin holds the registered checks. The zero value is ready to use.
+type Admin struct {
+	// Pprof adds the net/http/pprof handlers under /debug/pprof/.
+	Pprof bool
+
+	mu        sync.Mutex
+	started   time.Time
+	health    []*check
+	readiness []*check
+}
+
+// New returns an Admin that reports uptime from now on.
+func New() *Admin {
+	return &Admin{started: time.Now()}
+}
+
+// AddHealthCheck adds a liveness check. A failing health check means the
+// process should be restarted. Health checks count toward readiness too.
+func (a *Admin) AddHealthCheck(c Check) {
+	a.add(&a.health, c, "health")
+}
+
+// AddReadinessCheck adds a readiness check. A failing readiness check means
+// the process shouldn't get traffic for now, for example while a dependency
+// is unreachable or the server is draining.
+func (a *Admin) AddReadinessCheck(c Check) {
+	a.add(&a.readiness, c, "readiness")
+}
+
+func (a *Admin) add(checks *[]*check, c Check, kind string) {
+	if c.Name == "" || c.Run == nil {
+		panic("admin: check needs a name and a Run function")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	*checks = append(*checks, &check{Check: c, kind: kind})
+}
+
+// Register adds the admin endpoints to mux:
+//
+//	/healthz        health checks; 200 or 503
+//	/readyz         health and readiness checks; 200 or 503
+//	/buildinfo      module versions the binary was built from, as JSON
+//	/status         uptime, build and check results, as JSON
+//	/debug/pprof/   profiles, if Pprof is set
+func (a *Admin) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", a.serveChecks(false))
+	mux.HandleFunc("/readyz", a.serveChecks(true))
+	mux.HandleFunc("/buildinfo", a.serveBuildInfo)
+	mux.HandleFunc("/status", a.serveStatus)
+
+	if a.Pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+}
+
+// Handler returns a mux serving only the admin endpoints.
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	a.Register(mux)
+	return mux
+}
+
+// run runs the health checks and, if readiness is set, the readiness checks
+// concurrently and returns their results in registration order.
+func (a *Admin) run(ctx context.Context, readiness bool) []Result {
+	a.mu.Lock()
+	checks := append([]*check(nil), a.health...)
+	if readiness {
+		checks = append(checks, a.readiness...)
+	}
+	a.mu.Unlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			results[i] = c.result(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+	return results
+}
+
+func healthy(results []Result) bool {
+	for _, r := range results {
+		if !r.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// serveChecks answers in the style of the Kubernetes API server: a one-line
+// verdict, preceded by one line per check if the query has "verbose" or a
+// check failed.
+func (a *Admin) serveChecks(readiness bool) http.HandlerFunc {
+	name := "healthz"
+	if readiness {
+		name = "readyz"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		results := a.run(r.Context(), readiness)
+		ok := healthy(results)
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if _, verbose := r.URL.Query()["verbose"]; verbose || !ok {
+			for _, res := range results {
+				if res.OK {
+					_, _ = fmt.Fprintf(w, "[+]%s ok\n", res.Name)
+				} else {
+					_, _ = fmt.Fprintf(w, "[-]%s failed: %s\n", res.Name, res.Error)
+				}
+			}
+		}
+		if ok {
+			_, _ = fmt.Fprintf(w, "%s check passed\n", name)
+		} else {
+			_, _ = fmt.Fprintf(w, "%s check failed\n", name)
+		}
+	}
+}
+
+// BuildInfo describes the binary.
+type BuildInfo struct {
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	Deps      map[string]string `json:"deps,omitempty"`
+}
+
+func buildInfo() BuildInfo {
+	info := BuildInfo{GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.Path, info.Version = bi.Main.Path, bi.Main.Version
+	info.Deps = make(map[string]string, len(bi.Deps))
+	for _, d := range bi.Deps {
+		v := d.Version
+		if d.Replace != nil {
+			v = d.Replace.Path + " " + d.Replace.Version
+		}
+		info.Deps[d.Path] = strings.TrimSpace(v)
+	}
+	return info
+}
+
+func (a *Admin) serveBuildInfo(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, buildInfo())
+}
+
+// Status is the JSON summary served on /status.
+type Status struct {
+	Status  string    `json:"status"` // "ok", "unready" or "unhealthy"
+	Started time.Time `json:"started"`
+	Uptime  string    `json:"uptime"`
+	Build   BuildInfo `json:"build"`
+	Checks  []Result  `json:"checks"`
+}
+
+func (a *Admin) serveStatus(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	started := a.started
+	a.mu.Unlock()
+
+	results := a.run(r.Context(), true)
+	s := Status{
+		Status:  "ok",
+		Started: started,
+		Build:   buildInfo(),
+		Checks:  results,
+	}
+	if !started.IsZero() {
+		s.Uptime = time.Since(started).Round(time.Second).String()
+	}
+	for _, res := range results {
+		if res.OK {
+			continue
+		}
+		if res.Kind == "health" {
+			s.Status = "unhealthy"
+			break
+		}
+		s.Status = "unready"
+	}
+
+	code := http.StatusOK
+	if s.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, s)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
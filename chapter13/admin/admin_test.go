@@ -0,0 +1,177 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func get(h http.Handler, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestChecks(t *testing.T) {
+	a := New()
+	var dbDown int32
+	a.AddHealthCheck(Check{Name: "self", Run: func(context.Context) error { return nil }})
+	a.AddReadinessCheck(Check{Name: "db", Run: func(context.Context) error {
+		if atomic.LoadInt32(&dbDown) == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}})
+	h := a.Handler()
+
+	testCases := []struct {
+		dbDown   int32
+		target   string
+		code     int
+		contains string
+	}{
+		{0, "/healthz", http.StatusOK, "healthz check passed"},
+		{0, "/readyz", http.StatusOK, "readyz check passed"},
+		{0, "/readyz?verbose", http.StatusOK, "[+]db ok"},
+		{1, "/healthz", http.StatusOK, "healthz check passed"},
+		{1, "/readyz", http.StatusServiceUnavailable, "[-]db failed: connection refused"},
+	}
+	for _, c := range testCases {
+		atomic.StoreInt32(&dbDown, c.dbDown)
+		w := get(h, c.target)
+		if w.Code != c.code {
+			t.Errorf("%s: expected status %d; actual %d", c.target, c.code, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), c.contains) {
+			t.Errorf("%s: expected %q in %q", c.target, c.contains, w.Body)
+		}
+	}
+}
+
+func TestCheckTimeoutAndCache(t *testing.T) {
+	a := New()
+	var runs int32
+	a.AddHealthCheck(Check{
+		Name:     "stuck",
+		Timeout:  20 * time.Millisecond,
+		CacheTTL: time.Minute,
+		Run: func(context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			select {} // ignores its context
+		},
+	})
+	h := a.Handler()
+
+	start := time.Now()
+	w := get(h, "/healthz")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("check wasn't cut off: %v", elapsed)
+	}
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), ErrCheckTimeout.Error()) {
+		t.Errorf("expected timeout failure; actual %d %q", w.Code, w.Body)
+	}
+
+	_ = get(h, "/healthz")
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Errorf("expected cached result; check ran %d times", n)
+	}
+}
+
+func TestCheckProberDisconnects(t *testing.T) {
+	a := New()
+	var runs int32
+	started, release := make(chan struct{}), make(chan struct{})
+	a.AddReadinessCheck(Check{
+		Name:     "db",
+		Timeout:  5 * time.Second,
+		CacheTTL: time.Minute,
+		Run: func(ctx context.Context) error {
+			if atomic.AddInt32(&runs, 1) == 1 {
+				close(started)
+			}
+			select {
+			case <-release:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+	h := a.Handler()
+
+	// the first prober gives up while the check runs
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/readyz", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		close(done)
+	}()
+	<-started
+	cancel()
+	<-done
+
+	// the run carries on and its result serves the next prober
+	close(release)
+	if w := get(h, "/readyz"); w.Code != http.StatusOK {
+		t.Errorf("expected the check to pass; actual %d %q", w.Code, w.Body)
+	}
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Errorf("expected one run; check ran %d times", n)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	a := New()
+	a.AddHealthCheck(Check{Name: "self", Run: func(context.Context) error { return nil }})
+	a.AddReadinessCheck(Check{Name: "draining", Run: func(context.Context) error {
+		return errors.New("shutting down")
+	}})
+
+	w := get(a.Handler(), "/status")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d; actual %d", http.StatusServiceUnavailable, w.Code)
+	}
+	var s Status
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != "unready" || len(s.Checks) != 2 || s.Build.GoVersion == "" {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if s.Checks[1].Name != "draining" || s.Checks[1].OK || s.Checks[1].Error != "shutting down" {
+		t.Errorf("unexpected check result %+v", s.Checks[1])
+	}
+
+	w = get(a.Handler(), "/buildinfo")
+	var bi BuildInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &bi); err != nil || bi.GoVersion == "" {
+		t.Errorf("unexpected build info %q: %v", w.Body, err)
+	}
+}
+
+func TestPprof(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics/", http.NotFoundHandler())
+	a := New()
+	a.Register(mux)
+	if w := get(mux, "/debug/pprof/"); w.Code != http.StatusNotFound {
+		t.Errorf("expected pprof to be off by default; actual status %d", w.Code)
+	}
+
+	a.Pprof = true
+	mux = http.NewServeMux()
+	a.Register(mux)
+	if w := get(mux, "/debug/pprof/"); w.Code != http.StatusOK {
+		t.Errorf("expected pprof index; actual status %d", w.Code)
+	}
+	if w := get(mux, "/debug/pprof/goroutine?debug=1"); w.Code != http.StatusOK {
+		t.Errorf("expected goroutine profile; actual status %d", w.Code)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -10,17 +12,23 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"go-network/chapter09/graceful"
+	"go-network/chapter13/admin"
 	"go-network/chapter13/instrumentation/metrics"
 )
 
 var (
-	metricsAddr = flag.String("metrics", "127.0.0.1:8081", "metrics listen address")
-	webAddr     = flag.String("web", "127.0.0.1:8082", "web listen address")
+	metricsAddr  = flag.String("metrics", "127.0.0.1:8081", "metrics listen address")
+	webAddr      = flag.String("web", "127.0.0.1:8082", "web listen address")
+	pprofEnabled = flag.Bool("pprof", false, "serve profiles on the metrics listener")
+	drainDelay   = flag.Duration("drain", 2*time.Second,
+		"time /readyz reports not ready before the servers shut down")
 )
 
 func HelloHandler(w http.ResponseWriter, _ *http.Request) {
@@ -36,10 +44,10 @@ func HelloHandler(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
-func newHTTPServer(addr string, mux http.Handler, stateFunc func(conn net.Conn, state http.ConnState)) error {
+func newHTTPServer(r *graceful.Runner, addr string, mux http.Handler, stateFunc func(conn net.Conn, state http.ConnState)) (net.Addr, error) {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	srv := &http.Server{
@@ -49,11 +57,8 @@ func newHTTPServer(addr string, mux http.Handler, stateFunc func(conn net.Conn,
 		ReadHeaderTimeout: 1 * time.Second,
 		ConnState:         stateFunc,
 	}
-
-	go func() {
-		log.Fatal(srv.Serve(l))
-	}()
-	return nil
+	r.Add(srv, l)
+	return l.Addr(), nil
 }
 
 func connStateMetrics(_ net.Conn, state http.ConnState) {
@@ -66,22 +71,57 @@ func connStateMetrics(_ net.Conn, state http.ConnState) {
 	}
 }
 
+// adminMux serves metrics and the admin endpoints. /readyz fails once runner
+// starts shutting down. The runner's DrainDelay keeps the listener up long
+// enough for load balancers to see that before the web server drains.
+func adminMux(runner *graceful.Runner, pprof bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics/", promhttp.Handler())
+	status := admin.New()
+	status.Pprof = pprof
+	status.AddReadinessCheck(admin.Check{
+		Name: "serving",
+		Run: func(context.Context) error {
+			if !runner.Ready() {
+				return errors.New("shutting down")
+			}
+			return nil
+		},
+	})
+	status.Register(mux)
+	return mux
+}
+
 func main() {
 	flag.Parse()
 	rand.Seed(time.Now().UnixNano())
 
-	mux := http.NewServeMux()
-	mux.Handle("/metrics/", promhttp.Handler())
-	if err := newHTTPServer(*metricsAddr, mux, nil); err != nil {
+	runner := &graceful.Runner{
+		ShutdownTimeout: 5 * time.Second,
+		DrainDelay:      *drainDelay,
+		Logger:          log.New(os.Stderr, "", log.LstdFlags),
+	}
+
+	if _, err := newHTTPServer(runner, *metricsAddr, adminMux(runner, *pprofEnabled), nil); err != nil {
 		log.Fatal(err)
 	}
 	fmt.Printf("Metrics listening on %q ...\n", *metricsAddr)
 
-	if err := newHTTPServer(*webAddr, http.HandlerFunc(HelloHandler), connStateMetrics); err != nil {
+	if _, err := newHTTPServer(runner, *webAddr, http.HandlerFunc(HelloHandler), connStateMetrics); err != nil {
 		log.Fatal(err)
 	}
 	fmt.Printf("Web listening on %q ...\n\n", *webAddr)
 
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- runner.Run(ctx) }()
+	defer func() {
+		stop()
+		if err := <-served; err != nil {
+			log.Print(err)
+		}
+	}()
+
 	clients := 500
 	gets := 100
 	wg := new(sync.WaitGroup)
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"go-network/chapter09/graceful"
+)
+
+func TestReadyzDuringShutdown(t *testing.T) {
+	runner := &graceful.Runner{DrainDelay: time.Second}
+	addr, err := newHTTPServer(runner, "127.0.0.1:0", adminMux(runner, false), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- runner.Run(ctx) }()
+
+	readyz := func() int {
+		resp, err := http.Get("http://" + addr.String() + "/readyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := readyz(); code != http.StatusOK {
+		t.Fatalf("expected ready; actual status %d", code)
+	}
+
+	cancel()
+	for runner.Ready() {
+		time.Sleep(time.Millisecond)
+	}
+	// the listener is still up while load balancers notice
+	if code := readyz(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected %d while draining; actual %d", http.StatusServiceUnavailable, code)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
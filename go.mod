@@ -3,27 +3,29 @@ module go-network
 go 1.17
 
 require (
+	github.com/go-kit/kit v0.12.0
 	github.com/golang/protobuf v1.5.2
-	golang.org/x/net v0.0.0-20211206223403-eba003a116a9
-	golang.org/x/sys v0.0.0-20211205182925-97ca703d548d
+	github.com/prometheus/client_golang v1.11.0
+	go.uber.org/multierr v1.7.0
+	go.uber.org/zap v1.19.1
+	// the 2021 x/net/ipv4 links to syscall.recvmsg, which Go 1.23 and later
+	// refuse to link; chapter05 uses it for multicast and batch I/O
+	golang.org/x/net v0.17.0
+	golang.org/x/sys v0.13.0
+	google.golang.org/grpc v1.42.0
 	google.golang.org/protobuf v1.27.1
+	gopkg.in/fsnotify.v1 v1.4.7
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 )
 
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
-	github.com/go-kit/kit v0.12.0 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
-	github.com/prometheus/client_golang v1.11.0 // indirect
 	github.com/prometheus/client_model v0.2.0 // indirect
 	github.com/prometheus/common v0.30.0 // indirect
 	github.com/prometheus/procfs v0.7.3 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
-	go.uber.org/multierr v1.7.0 // indirect
-	go.uber.org/zap v1.19.1 // indirect
-	golang.org/x/text v0.3.7 // indirect
+	golang.org/x/text v0.13.0 // indirect
 	google.golang.org/genproto v0.0.0-20211206220100-3cb06788ce7f // indirect
-	google.golang.org/grpc v1.42.0 // indirect
-	gopkg.in/fsnotify.v1 v1.4.7 // indirect
-	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
 )
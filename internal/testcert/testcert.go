@@ -0,0 +1,124 @@
+// Package testcert generates self-signed certificates for tests, since
+// checked-in ones expire.
+package testcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// Options describe a certificate. The zero value is a certificate for
+// localhost and 127.0.0.1, valid from an hour ago to an hour from now.
+type Options struct {
+	// Hosts are DNS names, wildcards included, or IP addresses. The first
+	// is also the common name.
+	Hosts     []string
+	Serial    int64 // defaults to a random serial number
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// New returns a self-signed certificate for hosts, or for localhost and
+// 127.0.0.1 if there are none.
+func New(t testing.TB, hosts ...string) *tls.Certificate {
+	t.Helper()
+	return Generate(t, Options{Hosts: hosts})
+}
+
+// Generate returns a self-signed ECDSA P-256 certificate with its Leaf set.
+// It can sign for itself, so a pool holding it verifies it as a server
+// certificate.
+func Generate(t testing.TB, o Options) *tls.Certificate {
+	t.Helper()
+	if len(o.Hosts) == 0 {
+		o.Hosts = []string{"localhost", "127.0.0.1"}
+	}
+	if o.NotBefore.IsZero() {
+		o.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if o.NotAfter.IsZero() {
+		o.NotAfter = time.Now().Add(time.Hour)
+	}
+	serial := big.NewInt(o.Serial)
+	if o.Serial == 0 {
+		var err error
+		if serial, err = rand.Int(rand.Reader, big.NewInt(1<<62)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: o.Hosts[0]},
+		NotBefore:             o.NotBefore,
+		NotAfter:              o.NotAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range o.Hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// Pool returns a pool trusting certs.
+func Pool(certs ...*tls.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, c := range certs {
+		pool.AddCert(c.Leaf)
+	}
+	return pool
+}
+
+// Write writes cert and its key as PEM to certFn and keyFn. Each file is
+// written next to its destination and renamed into place, as rotation tools
+// do, so watchers never see a partial file.
+func Write(t testing.TB, cert *tls.Certificate, certFn, keyFn string) {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fn, block := range map[string]*pem.Block{
+		certFn: {Type: "CERTIFICATE", Bytes: cert.Certificate[0]},
+		keyFn:  {Type: "PRIVATE KEY", Bytes: keyDER},
+	} {
+		tmp := fn + ".tmp"
+		if err = ioutil.WriteFile(tmp, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Rename(tmp, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+}